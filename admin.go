@@ -1,2 +1,34 @@
 package main
 
+import (
+	"fmt"
+
+	"github.com/LittleJake/server-monitor-go/internal/controller"
+	"github.com/LittleJake/server-monitor-go/internal/middleware"
+	"github.com/LittleJake/server-monitor-go/internal/util"
+	"github.com/gin-gonic/gin"
+)
+
+// SetupAdminRouter registers the /admin group.
+// The panel is only enabled when a password hash is configured through
+// ADMIN_PASSWORD_HASH or ADMIN_PASSWORD_FILE; ADMIN_USERNAME defaults to "admin".
+func SetupAdminRouter(r *gin.Engine) {
+	if util.GetAdminPasswordHash() == "" {
+		fmt.Println("Admin panel disabled: ADMIN_PASSWORD_HASH is not set")
+		return
+	}
+
+	r.GET("/admin/login", controller.Admin.Login)
+	r.POST("/admin/login", controller.Admin.DoLogin)
+
+	admin := r.Group("/admin", middleware.AdminAuth(), middleware.CSRF())
+	{
+		admin.GET("/", controller.Admin.Index)
+		admin.POST("/logout", controller.Admin.Logout)
+		admin.PATCH("/node/:uuid", controller.Admin.Update)
+		admin.DELETE("/node/:uuid", controller.Admin.Delete)
+		admin.POST("/node/:uuid/token", controller.Admin.Token)
+		admin.POST("/purge", controller.Admin.Purge)
+		admin.POST("/clear", controller.Admin.Clear)
+	}
+}
@@ -0,0 +1,207 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LittleJake/server-monitor-go/internal/middleware"
+	"github.com/LittleJake/server-monitor-go/internal/util"
+	"github.com/gin-gonic/gin"
+)
+
+type AdminController struct{}
+
+var Admin = AdminController{}
+
+func (AdminController) Login(c *gin.Context) {
+	c.HTML(http.StatusOK, "admin/login.html", gin.H{
+		"base_url": util.Conf().Server.BaseURL,
+		"Context":  c,
+	})
+}
+
+// DoLogin checks the submitted credentials and issues a session cookie.
+// Clients are locked out for ADMIN_LOCKOUT_SECONDS after
+// ADMIN_MAX_LOGIN_ATTEMPTS consecutive failures.
+func (AdminController) DoLogin(c *gin.Context) {
+	ip := c.ClientIP()
+
+	allowed, err := util.RecordLoginAttempt(ip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed attempts, try again later"})
+		return
+	}
+
+	if !util.CheckAdminCredentials(c.PostForm("username"), c.PostForm("password")) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "wrong username or password"})
+		return
+	}
+	if err := util.ResetLoginFailures(ip); err != nil {
+		fmt.Println("Error resetting login failures:", err)
+	}
+
+	ttl := time.Duration(util.Conf().Admin.SessionTTL) * time.Second
+	_, value, err := util.NewSession(c.PostForm("username"), ttl)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	setSessionCookie(c, value, int(ttl.Seconds()))
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (AdminController) Logout(c *gin.Context) {
+	if s, ok := c.Get(middleware.CtxAdminSessionKey); ok {
+		if err := util.RevokeSession(s.(*util.Session)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	setSessionCookie(c, "", -1)
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func setSessionCookie(c *gin.Context, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(
+		middleware.AdminSessionCookie,
+		value,
+		maxAge,
+		"/",
+		"",
+		c.Request.TLS != nil || util.Conf().Admin.CookieSecure,
+		true,
+	)
+}
+
+func (AdminController) Index(c *gin.Context) {
+	result := gin.H{
+		"base_url": util.Conf().Server.BaseURL,
+		"Context":  c,
+	}
+	if s, ok := c.Get(middleware.CtxAdminSessionKey); ok {
+		result["csrf_token"] = s.(*util.Session).CSRF
+	}
+
+	if c.Request.Header.Get("X-Requested-With") != "XMLHttpRequest" {
+		c.HTML(http.StatusOK, "admin/index.html", result)
+		return
+	}
+
+	uuids, err := util.GetUUIDs(true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve nodes"})
+		return
+	}
+	names, _ := util.GetDisplayName(true)
+	hidden, _ := util.GetHiddenNodes(true)
+
+	info := map[string]map[string]string{}
+	full := map[string]gin.H{}
+	for uuid := range uuids {
+		info[uuid], _ = util.GetInfo(uuid, false)
+		forecast, _ := util.GetDiskForecast(uuid)
+		if mountpoint, days, ok := util.SoonestFull(forecast); ok {
+			full[uuid] = gin.H{"mountpoint": mountpoint, "days": days}
+		}
+	}
+
+	result["uuids"] = uuids
+	result["names"] = names
+	result["hidden"] = hidden
+	result["info"] = info
+	result["full"] = full
+	c.HTML(http.StatusOK, "admin/index_ajax.html", result)
+}
+
+// Update renames a node (form field `rename`) or toggles its visibility on
+// the dashboard (form field `display`, 1 or 0).
+func (AdminController) Update(c *gin.Context) {
+	uuid := c.Param("uuid")
+	if !util.ValidUUID(uuid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid uuid parameter"})
+		return
+	}
+
+	if name, ok := c.GetPostForm("rename"); ok {
+		if err := util.SetDisplayName(uuid, strings.TrimSpace(name)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+		return
+	}
+
+	if display, ok := c.GetPostForm("display"); ok {
+		show, err := strconv.ParseBool(display)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "display must be 1 or 0"})
+			return
+		}
+		if err := util.SetNodeHidden(uuid, !show); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+		return
+	}
+
+	c.JSON(http.StatusBadRequest, gin.H{"error": "nothing to update"})
+}
+
+func (AdminController) Delete(c *gin.Context) {
+	uuid := c.Param("uuid")
+	if !util.ValidUUID(uuid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid uuid parameter"})
+		return
+	}
+	if err := util.DeleteNode(uuid); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Token generates a new report secret for the node and returns it once.
+func (AdminController) Token(c *gin.Context) {
+	uuid := c.Param("uuid")
+	if !util.ValidUUID(uuid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid uuid parameter"})
+		return
+	}
+
+	token, err := util.GenerateReportToken()
+	if err == nil {
+		err = util.SetReportToken(uuid, token)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "token": token})
+}
+
+func (AdminController) Purge(c *gin.Context) {
+	n, err := util.PurgeNodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "removed": n})
+}
+
+func (AdminController) Clear(c *gin.Context) {
+	n, err := util.RemoveInvalidNodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "removed": n})
+}
@@ -21,14 +21,22 @@ func (BatteryAPI) Get(c *gin.Context) {
 		return
 	}
 
-	result, err := util.GetCollection(uuid, false)
+	query, err := parseCollectionQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	result, err := util.GetCollectionHistory(uuid, query.Start, query.End, query.Agg.Func)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
-	battery := util.CollectionFormat(result, "Battery")
+	battery := util.CollectionFormat(result, "Battery", query.Agg)
 	c.JSON(http.StatusOK, battery)
 
 }
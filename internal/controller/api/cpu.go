@@ -22,13 +22,21 @@ func (CpuAPI) Get(c *gin.Context) {
 		return
 	}
 
-	result, err := util.GetCollection(uuid, false)
+	query, err := parseCollectionQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	result, err := util.GetCollectionHistory(uuid, query.Start, query.End, query.Agg.Func)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
-	load := util.CollectionFormat(result, "Load")
+	load := util.CollectionFormat(result, "Load", query.Agg)
 	c.JSON(http.StatusOK, load)
 }
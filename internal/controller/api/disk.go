@@ -22,13 +22,21 @@ func (DiskAPI) Get(c *gin.Context) {
 		return
 	}
 
-	result, err := util.GetCollection(uuid, false)
+	query, err := parseCollectionQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	result, err := util.GetCollectionHistory(uuid, query.Start, query.End, query.Agg.Func)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
-	disk := util.CollectionFormat(result, "Disk")
+	disk := util.CollectionFormat(result, "Disk", query.Agg)
 	c.JSON(http.StatusOK, disk)
 }
@@ -21,7 +21,18 @@ func (IOAPI) Get(c *gin.Context) {
 		return
 	}
 
-	result, err := util.GetCollection(uuid, false)
+	query, err := parseCollectionQuery(c)
+	if err == nil {
+		query.Names, err = parseNameFilter(c.Query("device"))
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	result, err := util.GetCollectionHistory(uuid, query.Start, query.End, query.Agg.Func)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
@@ -29,6 +40,7 @@ func (IOAPI) Get(c *gin.Context) {
 		return
 	}
 
-	io := util.CollectionFormat(result, "IO")
+	io := util.CollectionFormat(result, "IO", query.Agg)
+	filterBreakdown(io, "devices", query.Names)
 	c.JSON(http.StatusOK, io)
 }
@@ -22,13 +22,25 @@ func (NetworkAPI) Get(c *gin.Context) {
 		return
 	}
 
-	result, err := util.GetCollection(uuid, false)
+	query, err := parseCollectionQuery(c)
+	if err == nil {
+		query.Names, err = parseNameFilter(c.Query("interface"))
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	result, err := util.GetCollectionHistory(uuid, query.Start, query.End, query.Agg.Func)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
-	network := util.CollectionFormat(result, "Network")
+	network := util.CollectionFormat(result, "Network", query.Agg)
+	filterBreakdown(network, "interfaces", query.Names)
 	c.JSON(http.StatusOK, network)
 }
@@ -0,0 +1,157 @@
+package api
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LittleJake/server-monitor-go/internal/util"
+	"github.com/gin-gonic/gin"
+)
+
+// collectionQuery holds the time range and downsampling parameters shared by
+// the /api/*/:uuid endpoints.
+type collectionQuery struct {
+	Start int64
+	End   int64
+	Agg   util.Aggregation
+	// Names filters the per-interface or per-device breakdown.
+	Names []string
+}
+
+// parseCollectionQuery reads start/end (see parseQueryTime), step (seconds or
+// a duration such as 5m) and agg (avg, min, max, last or p95; only the first
+// three before DATA_RETENTION_DAYS).
+func parseCollectionQuery(c *gin.Context) (collectionQuery, error) {
+	q := collectionQuery{
+		Agg: util.Aggregation{
+			Func:      c.DefaultQuery("agg", "avg"),
+			MaxPoints: util.Conf().Server.APIMaxPoints,
+		},
+	}
+
+	now := time.Now()
+	var err error
+	if q.Start, err = parseQueryTime(c.Query("start"), now); err != nil {
+		return q, fmt.Errorf("invalid start: %w", err)
+	}
+	if q.End, err = parseQueryTime(c.Query("end"), now); err != nil {
+		return q, fmt.Errorf("invalid end: %w", err)
+	}
+	if q.Start > now.Unix() {
+		return q, fmt.Errorf("invalid range: start is in the future")
+	}
+	if q.End > 0 && q.End < q.Start {
+		return q, fmt.Errorf("invalid range: end is before start")
+	}
+
+	if v := c.Query("step"); v != "" {
+		q.Agg.Step, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			d, derr := parseDuration(v)
+			if derr != nil {
+				return q, fmt.Errorf("invalid step: %q", v)
+			}
+			q.Agg.Step = int64(d / time.Second)
+		}
+		if q.Agg.Step <= 0 {
+			return q, fmt.Errorf("invalid step: %q", v)
+		}
+	}
+
+	if !util.ValidAggregation(q.Agg.Func) {
+		return q, fmt.Errorf("invalid agg: %q, expected one of %v", q.Agg.Func, util.AggregationFuncs)
+	}
+	if util.InRollup(q.Start) && !slices.Contains(util.RollupFuncs, q.Agg.Func) {
+		return q, fmt.Errorf("invalid agg: %q is not kept beyond DATA_RETENTION_DAYS, expected one of %v", q.Agg.Func, util.RollupFuncs)
+	}
+	return q, nil
+}
+
+// parseQueryTime accepts unix seconds, RFC3339, "now" and times relative to
+// now such as -6h or -7d. An empty value yields 0, meaning unbounded.
+func parseQueryTime(v string, now time.Time) (int64, error) {
+	switch {
+	case v == "":
+		return 0, nil
+	case v == "now":
+		return now.Unix(), nil
+	case strings.HasPrefix(v, "-"):
+		d, err := parseDuration(v[1:])
+		if err != nil {
+			return 0, err
+		}
+		return now.Add(-d).Unix(), nil
+	}
+
+	if ts, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return ts, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not unix seconds, RFC3339 or a relative time like -6h", v)
+	}
+	return t.Unix(), nil
+}
+
+// parseDuration is time.ParseDuration with an extra "d" unit for days.
+func parseDuration(v string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid duration %q", v)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", v)
+	}
+	return d, nil
+}
+
+// parseNameFilter reads a comma separated list of names or path.Match
+// patterns such as eth*, used by ?interface= and ?device=.
+func parseNameFilter(v string) ([]string, error) {
+	if v == "" {
+		return nil, nil
+	}
+	patterns := strings.Split(v, ",")
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil || p == "" {
+			return nil, fmt.Errorf("invalid filter: %q", p)
+		}
+	}
+	return patterns, nil
+}
+
+// filterBreakdown keeps only the names matching patterns in a per-interface or
+// per-device breakdown shaped {dir: {field: {name: series}}}.
+func filterBreakdown(result map[string]interface{}, key string, patterns []string) {
+	breakdown, ok := result[key].(map[string]interface{})
+	if !ok || len(patterns) == 0 {
+		return
+	}
+	for _, fields := range breakdown {
+		for _, names := range fields.(map[string]interface{}) {
+			names := names.(map[string]interface{})
+			for name := range names {
+				if !matchAny(patterns, name) {
+					delete(names, name)
+				}
+			}
+		}
+	}
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LittleJake/server-monitor-go/internal/util"
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseQueryTime(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"now", 1700000000, false},
+		{"1600000000", 1600000000, false},
+		{"-30m", 1700000000 - 1800, false},
+		{"-6h", 1700000000 - 6*3600, false},
+		{"-7d", 1700000000 - 7*86400, false},
+		{"-1.5d", 1700000000 - 36*3600, false},
+		{"2023-11-14T22:13:20Z", 1700000000, false},
+		{"2023-11-15T06:13:20+08:00", 1700000000, false},
+		{"-0h", 0, true},
+		{"-7x", 0, true},
+		{"yesterday", 0, true},
+		{"2023-11-14", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseQueryTime(tt.in, now)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseQueryTime(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseCollectionQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		query   string
+		want    util.Aggregation
+		wantErr string
+	}{
+		{"", util.Aggregation{Func: "avg"}, ""},
+		{"step=300&agg=max", util.Aggregation{Step: 300, Func: "max"}, ""},
+		{"step=5m&agg=p95", util.Aggregation{Step: 300, Func: "p95"}, ""},
+		{"step=1d", util.Aggregation{Step: 86400, Func: "avg"}, ""},
+		{"step=0", util.Aggregation{}, "invalid step"},
+		{"step=-5", util.Aggregation{}, "invalid step"},
+		{"step=soon", util.Aggregation{}, "invalid step"},
+		{"agg=median", util.Aggregation{}, "invalid agg"},
+		{"start=-1d&agg=p95", util.Aggregation{Func: "p95"}, ""},
+		{"start=-30d&agg=min", util.Aggregation{Func: "min"}, ""},
+		{"start=-30d&agg=p95", util.Aggregation{}, "not kept beyond DATA_RETENTION_DAYS"},
+		{"start=-30d&agg=last", util.Aggregation{}, "not kept beyond DATA_RETENTION_DAYS"},
+		{"start=-1h&end=-2h", util.Aggregation{}, "end is before start"},
+		{"start=9999999999", util.Aggregation{}, "start is in the future"},
+		{"start=abc", util.Aggregation{}, "invalid start"},
+		{"end=abc", util.Aggregation{}, "invalid end"},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/api/cpu/n1?"+tt.query, nil)
+		q, err := parseCollectionQuery(c)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%q: error = %v, want %q", tt.query, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || q.Agg != tt.want {
+			t.Errorf("%q: agg = %+v, %v; want %+v", tt.query, q.Agg, err, tt.want)
+		}
+	}
+}
+
+func TestParseNameFilter(t *testing.T) {
+	if got, err := parseNameFilter(""); got != nil || err != nil {
+		t.Errorf("parseNameFilter(\"\") = %v, %v", got, err)
+	}
+	if got, _ := parseNameFilter("eth*,wg0"); !reflect.DeepEqual(got, []string{"eth*", "wg0"}) {
+		t.Errorf("parseNameFilter() = %v", got)
+	}
+	for _, v := range []string{"eth[", "eth0,", ","} {
+		if _, err := parseNameFilter(v); err == nil {
+			t.Errorf("parseNameFilter(%q) returned no error", v)
+		}
+	}
+}
+
+func TestFilterBreakdown(t *testing.T) {
+	result := map[string]interface{}{
+		"interfaces": map[string]interface{}{
+			"RX": map[string]interface{}{
+				"kilobytes": map[string]interface{}{"eth0": 1, "eth1": 2, "wg0": 3, "lo": 4},
+			},
+		},
+	}
+	filterBreakdown(result, "interfaces", []string{"eth*", "wg0"})
+	got := result["interfaces"].(map[string]interface{})["RX"].(map[string]interface{})["kilobytes"]
+	want := map[string]interface{}{"eth0": 1, "eth1": 2, "wg0": 3}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("filterBreakdown() = %v, want %v", got, want)
+	}
+}
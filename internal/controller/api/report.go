@@ -1,6 +1,11 @@
 package api
 
 import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/LittleJake/server-monitor-go/internal/util"
 	"github.com/gin-gonic/gin"
 )
 
@@ -8,4 +13,56 @@ type ReportAPI struct{}
 
 var Report = ReportAPI{}
 
-func (ReportAPI) Set(c *gin.Context) {}
+func (ReportAPI) Set(c *gin.Context) {
+	uuid, ok := c.Params.Get("uuid")
+
+	if !ok || !util.ValidUUID(uuid) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid uuid parameter",
+		})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, util.MaxReportSize))
+	if err != nil {
+		if errors.As(err, new(*http.MaxBytesError)) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error": "report too large",
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "failed to read report",
+		})
+		return
+	}
+
+	report, err := util.ParseReport(body)
+	if err != nil {
+		var verr *util.ValidationError
+		if errors.As(err, &verr) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":  "invalid report",
+				"fields": verr.Fields,
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if err := util.SaveReport(uuid, report); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "ok",
+		"uuid":      uuid,
+		"timestamp": report.Timestamp,
+	})
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/LittleJake/server-monitor-go/internal/util"
+	"github.com/gin-gonic/gin"
+)
+
+// failingReader stands for a client going away in the middle of its report.
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("connection reset") }
+
+func TestReportSetReadErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := map[string]struct {
+		body     io.Reader
+		code     int
+		contains string
+	}{
+		"too large":    {bytes.NewReader(make([]byte, util.MaxReportSize+1)), http.StatusRequestEntityTooLarge, "too large"},
+		"read failure": {io.MultiReader(strings.NewReader("{"), failingReader{}), http.StatusBadRequest, "failed to read"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/api/report/n1", tt.body)
+			c.Params = gin.Params{{Key: "uuid", Value: "n1"}}
+			Report.Set(c)
+			if w.Code != tt.code || !strings.Contains(w.Body.String(), tt.contains) {
+				t.Errorf("Set() = %d %s, want %d with %q", w.Code, w.Body, tt.code, tt.contains)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/LittleJake/server-monitor-go/internal/util"
+	"github.com/gin-gonic/gin"
+)
+
+type StreamAPI struct{}
+
+var Stream = StreamAPI{}
+
+// Get streams node updates as Server-Sent Events.
+// With ?uuid= only that node is streamed and collection events carry the
+// chart series for it, otherwise every visible node is streamed.
+func (StreamAPI) Get(c *gin.Context) {
+	uuid := c.Query("uuid")
+	if uuid != "" && !util.ValidUUID(uuid) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "invalid uuid",
+		})
+		return
+	}
+
+	events, err := util.Events.Subscribe(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+
+	c.SSEvent("ready", gin.H{"time": time.Now().Unix()})
+	// Flush now, c.Stream only flushes after the first event.
+	c.Writer.Flush()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return false
+			}
+			if uuid != "" && e.UUID != uuid {
+				return true
+			}
+			if hidden, _ := util.GetHiddenNodes(false); hidden[e.UUID] != "" {
+				return true
+			}
+
+			data := gin.H{
+				"uuid":      e.UUID,
+				"timestamp": e.Timestamp,
+				"time":      time.Unix(e.Timestamp, 0).Format("01-02 15:04"),
+			}
+			if e.Type == util.EventCollection {
+				data["info"] = e.Info
+				if uuid != "" {
+					data["series"] = e.Series()
+				}
+			}
+			c.SSEvent(e.Type, data)
+		case <-keepalive.C:
+			_, _ = io.WriteString(w, ": keepalive\n\n")
+		}
+		return true
+	})
+}
@@ -22,14 +22,22 @@ func (ThermalAPI) Get(c *gin.Context) {
 		return
 	}
 
-	result, err := util.GetCollection(uuid, false)
+	query, err := parseCollectionQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	result, err := util.GetCollectionHistory(uuid, query.Start, query.End, query.Agg.Func)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
-	thermal := util.CollectionFormat(result, "Thermal")
+	thermal := util.CollectionFormat(result, "Thermal", query.Agg)
 	c.JSON(http.StatusOK, thermal)
 
 }
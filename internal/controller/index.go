@@ -21,7 +21,7 @@ func (IndexController) Index(c *gin.Context) {
 	name, _ := util.GetDisplayName(false)
 
 	c.HTML(http.StatusOK, "index.html", gin.H{
-		"base_url": util.GetEnv("BASE_URL", ""),
+		"base_url": util.Conf().Server.BaseURL,
 		"Context":  c,
 		"online":   online,
 		"offline":  offline,
@@ -41,7 +41,7 @@ func (IndexController) List(c *gin.Context) {
 	name, _ := util.GetDisplayName(false)
 
 	result := gin.H{
-		"base_url": util.GetEnv("BASE_URL", ""),
+		"base_url": util.Conf().Server.BaseURL,
 		"Context":  c,
 		"online":   online,
 		"offline":  offline,
@@ -71,13 +71,15 @@ func (IndexController) Info(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve latest collection data"})
 		return
 	}
+	forecast, _ := util.GetDiskForecast(uuid)
 
 	if c.Request.Header.Get("X-Requested-With") == "XMLHttpRequest" {
 		c.HTML(http.StatusOK, "info_ajax.html", gin.H{
 			"uuid":     uuid,
-			"base_url": util.GetEnv("BASE_URL", ""),
+			"base_url": util.Conf().Server.BaseURL,
 			"info":     info,
 			"latest":   latest,
+			"forecast": forecast,
 			"Context":  c,
 		})
 		return
@@ -85,9 +87,10 @@ func (IndexController) Info(c *gin.Context) {
 
 	c.HTML(http.StatusOK, "info.html", gin.H{
 		"uuid":     uuid,
-		"base_url": util.GetEnv("BASE_URL", ""),
+		"base_url": util.Conf().Server.BaseURL,
 		"info":     info,
 		"latest":   latest,
+		"forecast": forecast,
 		"Context":  c,
 	})
 	// c.JSON(http.StatusOK, gin.H{"id": uuid, "name": "example"})
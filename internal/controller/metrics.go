@@ -0,0 +1,32 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/LittleJake/server-monitor-go/internal/util"
+	"github.com/gin-gonic/gin"
+)
+
+type MetricsController struct{}
+
+var Metrics = MetricsController{}
+
+// Get serves the Prometheus metrics of every node.
+// When METRICS_TOKEN is set scrapers must send it as a bearer token.
+func (MetricsController) Get(c *gin.Context) {
+	if token := util.Conf().Server.MetricsToken; token != "" {
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte("Bearer "+token)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid metrics token"})
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := util.WritePrometheusMetrics(&buf); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", buf.Bytes())
+}
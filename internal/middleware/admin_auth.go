@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/LittleJake/server-monitor-go/internal/util"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	AdminSessionCookie = "admin_session"
+	CtxAdminSessionKey = "adminSession"
+)
+
+// AdminAuth requires a valid signed session cookie.
+// Page requests are redirected to the login form, ajax requests get a 401.
+func AdminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, err := c.Cookie(AdminSessionCookie)
+		if err == nil {
+			if s, err := util.ParseSession(value); err == nil {
+				c.Set(CtxAdminSessionKey, s)
+				c.Next()
+				return
+			}
+		}
+
+		if c.Request.Method == http.MethodGet && c.GetHeader("X-Requested-With") != "XMLHttpRequest" {
+			c.Redirect(http.StatusFound, util.Conf().Server.BaseURL+"/admin/login")
+			c.Abort()
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "login required"})
+	}
+}
+
+// CSRF rejects state changing requests whose X-CSRF-Token header (or
+// csrf_token form field) does not match the token of the admin session.
+// It must run after AdminAuth.
+func CSRF() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		s, ok := c.MustGet(CtxAdminSessionKey).(*util.Session)
+		token := c.GetHeader("X-CSRF-Token")
+		if token == "" {
+			token = c.PostForm("csrf_token")
+		}
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.CSRF)) != 1 {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "invalid csrf token"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/LittleJake/server-monitor-go/internal/util"
+	"github.com/gin-gonic/gin"
+)
+
+func setupAdminAuth(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	old := util.DataStore
+	util.DataStore = util.NewMemoryStore()
+	t.Cleanup(func() {
+		util.DataStore.Close()
+		util.DataStore = old
+	})
+
+	r := gin.New()
+	ok := func(c *gin.Context) { c.String(http.StatusOK, "ok") }
+	admin := r.Group("/admin", AdminAuth(), CSRF())
+	admin.GET("/", ok)
+	admin.POST("/node", ok)
+	return r
+}
+
+func TestAdminAuth(t *testing.T) {
+	r := setupAdminAuth(t)
+	s, cookie, err := util.NewSession("admin", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, expired, _ := util.NewSession("admin", -time.Hour)
+	logout, revoked, _ := util.NewSession("admin", time.Hour)
+	if err := util.RevokeSession(logout); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		method, cookie, csrf string
+		ajax                 bool
+		want                 int
+	}{
+		"page without session": {method: http.MethodGet, want: http.StatusFound},
+		"ajax without session": {method: http.MethodGet, ajax: true, want: http.StatusUnauthorized},
+		"post without session": {method: http.MethodPost, csrf: s.CSRF, want: http.StatusUnauthorized},
+		"expired session":      {method: http.MethodGet, cookie: expired, ajax: true, want: http.StatusUnauthorized},
+		"tampered session":     {method: http.MethodGet, cookie: cookie + "x", ajax: true, want: http.StatusUnauthorized},
+		"revoked session":      {method: http.MethodGet, cookie: revoked, ajax: true, want: http.StatusUnauthorized},
+		"page":                 {method: http.MethodGet, cookie: cookie, want: http.StatusOK},
+		"post":                 {method: http.MethodPost, cookie: cookie, csrf: s.CSRF, want: http.StatusOK},
+		"post without csrf":    {method: http.MethodPost, cookie: cookie, want: http.StatusForbidden},
+		"post with wrong csrf": {method: http.MethodPost, cookie: cookie, csrf: "wrong", want: http.StatusForbidden},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := "/admin/"
+			if tt.method == http.MethodPost {
+				path = "/admin/node"
+			}
+			req := httptest.NewRequest(tt.method, path, strings.NewReader(""))
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: AdminSessionCookie, Value: tt.cookie})
+			}
+			if tt.csrf != "" {
+				req.Header.Set("X-CSRF-Token", tt.csrf)
+			}
+			if tt.ajax {
+				req.Header.Set("X-Requested-With", "XMLHttpRequest")
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want == http.StatusFound && !strings.HasSuffix(w.Header().Get("Location"), "/admin/login") {
+				t.Errorf("redirected to %q", w.Header().Get("Location"))
+			}
+		})
+	}
+}
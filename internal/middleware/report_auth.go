@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/LittleJake/server-monitor-go/internal/util"
+	"github.com/gin-gonic/gin"
+)
+
+// ReportAuth authenticates agents posting to /api/report/:uuid with the
+// per-node secret stored in `system_monitor:token`.
+//
+// Two schemes are accepted:
+//
+//	Authorization: Bearer <token>
+//	X-Signature: hex(HMAC-SHA256(token, timestamp + "." + body))
+//
+// Both require X-Timestamp: <unix seconds>, within REPORT_MAX_SKEW seconds
+// (default 300) of the server time. A request can only be used once: the
+// signature, or the hash of the timestamp and body with a bearer token, is
+// remembered for twice the skew.
+func ReportAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uuid := c.Param("uuid")
+		skew := time.Duration(util.Conf().Server.ReportMaxSkew) * time.Second
+
+		signature := strings.TrimPrefix(c.GetHeader("X-Signature"), "sha256=")
+		bearer, hasBearer := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if signature == "" && !hasBearer {
+			unauthorized(c, "missing credentials")
+			return
+		}
+
+		timestamp := c.GetHeader("X-Timestamp")
+		if timestamp == "" {
+			unauthorized(c, "missing X-Timestamp header")
+			return
+		}
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			unauthorized(c, "invalid X-Timestamp header")
+			return
+		}
+		if d := time.Since(time.Unix(ts, 0)); d > skew || d < -skew {
+			unauthorized(c, "stale timestamp")
+			return
+		}
+
+		secret, err := util.GetReportToken(uuid)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if secret == "" {
+			forbidden(c, "no token registered for this uuid")
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, util.MaxReportSize))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		var nonce string
+		if signature == "" {
+			if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(bearer)), []byte(secret)) != 1 {
+				forbidden(c, "invalid token")
+				return
+			}
+			h := sha256.New()
+			fmt.Fprintf(h, "%s.", timestamp)
+			h.Write(body)
+			nonce = hex.EncodeToString(h.Sum(nil))
+		} else {
+			mac := hmac.New(sha256.New, []byte(secret))
+			fmt.Fprintf(mac, "%s.", timestamp)
+			mac.Write(body)
+			nonce = hex.EncodeToString(mac.Sum(nil))
+
+			if !hmac.Equal([]byte(strings.ToLower(signature)), []byte(nonce)) {
+				forbidden(c, "invalid signature")
+				return
+			}
+		}
+
+		fresh, err := util.ClaimReportNonce(uuid, nonce, 2*skew)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !fresh {
+			unauthorized(c, "replayed request")
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func unauthorized(c *gin.Context, message string) {
+	c.Header("WWW-Authenticate", `Bearer realm="report"`)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": message})
+}
+
+func forbidden(c *gin.Context, message string) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": message})
+}
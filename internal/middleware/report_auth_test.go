@@ -0,0 +1,108 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/LittleJake/server-monitor-go/internal/util"
+	"github.com/gin-gonic/gin"
+)
+
+const testToken = "secret"
+
+// setupReportAuth serves ReportAuth in front of a handler echoing the body,
+// with the token of node n1 registered in a MemoryStore.
+func setupReportAuth(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	old := util.DataStore
+	util.DataStore = util.NewMemoryStore()
+	t.Cleanup(func() {
+		util.DataStore.Close()
+		util.DataStore = old
+	})
+	if err := util.SetReportToken("n1", testToken); err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.POST("/api/report/:uuid", ReportAuth(), func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, "%d", len(body))
+	})
+	return r
+}
+
+func signedRequest(body []byte) *http.Request {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(testToken))
+	fmt.Fprintf(mac, "%s.", ts)
+	mac.Write(body)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/report/n1", bytes.NewReader(body))
+	req.Header.Set("X-Timestamp", ts)
+	req.Header.Set("X-Signature", hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestReportAuth(t *testing.T) {
+	r := setupReportAuth(t)
+	bearer := func(token, body string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/api/report/n1", bytes.NewReader([]byte(body)))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("X-Timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+		return req
+	}
+	unsigned := httptest.NewRequest(http.MethodPost, "/api/report/n1", nil)
+	unknown := bearer(testToken, "{}")
+	unknown.URL.Path = "/api/report/n2"
+	untimed := bearer(testToken, "{}")
+	untimed.Header.Del("X-Timestamp")
+	stale := bearer(testToken, "{}")
+	stale.Header.Set("X-Timestamp", strconv.FormatInt(time.Now().Unix()-3600, 10))
+
+	tests := map[string]struct {
+		req  *http.Request
+		want int
+	}{
+		"bearer":            {bearer(testToken, "{}"), http.StatusOK},
+		"bearer untimed":    {untimed, http.StatusUnauthorized},
+		"bearer stale":      {stale, http.StatusUnauthorized},
+		"wrong bearer":      {bearer("wrong", "{}"), http.StatusForbidden},
+		"no credentials":    {unsigned, http.StatusUnauthorized},
+		"unknown node":      {unknown, http.StatusForbidden},
+		"signed":            {signedRequest([]byte("{}")), http.StatusOK},
+		"signed too large":  {signedRequest(make([]byte, util.MaxReportSize+1)), http.StatusRequestEntityTooLarge},
+		"signed at the max": {signedRequest(make([]byte, util.MaxReportSize)), http.StatusOK},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, tt.req)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+
+	// A captured bearer request cannot be sent again.
+	first := bearer(testToken, "[1]")
+	replayed := bearer(testToken, "[1]")
+	replayed.Header = first.Header.Clone()
+	for i, want := range []int{http.StatusOK, http.StatusUnauthorized} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, []*http.Request{first, replayed}[i])
+		if w.Code != want {
+			t.Errorf("bearer request %d = %d, want %d: %s", i+1, w.Code, want, w.Body)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"github.com/LittleJake/server-monitor-go/internal/util"
+	"github.com/gin-gonic/gin"
+)
+
+// VisibleNode answers with notFound for the nodes hidden from the dashboard,
+// so that their pages and data are not reachable by uuid either.
+func VisibleNode(notFound gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if util.IsNodeHidden(c.Param("uuid")) {
+			notFound(c)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,142 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/LittleJake/server-monitor-go/internal/util"
+)
+
+// DefaultTemplate renders an alert with Alert.String().
+const DefaultTemplate = "{{ . }}"
+
+var funcMap = template.FuncMap{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"datetime": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04:05")
+	},
+}
+
+// NewTemplate parses a message template. The template receives a util.Alert,
+// e.g. `{{ .Name }} {{ .Rule }} is {{ .State | upper }}`.
+func NewTemplate(name, text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultTemplate
+	}
+	return template.New(name).Funcs(funcMap).Parse(text)
+}
+
+func render(t *template.Template, alert util.Alert) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, alert); err != nil {
+		return "", fmt.Errorf("render %s template: %w", t.Name(), err)
+	}
+	return buf.String(), nil
+}
+
+// Retry controls how often a notification is attempted.
+// The delay doubles after every failed attempt.
+type Retry struct {
+	Attempts int
+	Backoff  time.Duration
+}
+
+// permanentError marks failures that will not succeed on retry, e.g. HTTP 4xx.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+func (r Retry) do(ctx context.Context, fn func() error) error {
+	attempts := max(r.Attempts, 1)
+	delay := r.Backoff
+
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		var perm permanentError
+		if errors.As(err, &perm) || i == attempts-1 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+	return err
+}
+
+// FromConfig builds every notifier that is configured in c. See the README
+// for the list of settings.
+func FromConfig(c util.AlertConfig) ([]util.Notifier, error) {
+	retry := Retry{
+		Attempts: c.RetryAttempts,
+		Backoff:  time.Duration(c.RetryBackoff) * time.Second,
+	}
+	result := []util.Notifier{}
+
+	if url := c.WebhookURL; url != "" {
+		t, err := NewTemplate("webhook", c.WebhookTemplate)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &Webhook{URL: url, Template: t, Retry: retry})
+	}
+
+	if token := c.TelegramToken; token != "" {
+		t, err := NewTemplate("telegram", c.TelegramTemplate)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &Telegram{
+			Token:    token,
+			ChatID:   c.TelegramChatID,
+			APIURL:   c.TelegramAPIURL,
+			Template: t,
+			Retry:    retry,
+		})
+	}
+
+	if host := c.SMTPHost; host != "" {
+		subject, err := NewTemplate("smtp-subject", c.SMTPSubject)
+		if err != nil {
+			return nil, err
+		}
+		body, err := NewTemplate("smtp", c.SMTPTemplate)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &SMTP{
+			Addr:     fmt.Sprintf("%s:%d", host, c.SMTPPort),
+			Username: c.SMTPUsername,
+			Password: c.SMTPPassword,
+			From:     c.SMTPFrom,
+			To:       splitList(c.SMTPTo),
+			Subject:  subject,
+			Template: body,
+			Retry:    retry,
+		})
+	}
+
+	return result, nil
+}
+
+func splitList(s string) []string {
+	result := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,125 @@
+package notifier
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/LittleJake/server-monitor-go/internal/util"
+)
+
+// smtpTimeout bounds a whole delivery when the context has no earlier deadline.
+const smtpTimeout = time.Minute
+
+// SMTP sends alerts by email. Authentication is only used when Username is set.
+// STARTTLS is used whenever the server offers it.
+type SMTP struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+	Subject  *template.Template
+	Template *template.Template
+	Retry    Retry
+}
+
+func (s *SMTP) Name() string { return "smtp" }
+
+func (s *SMTP) Notify(ctx context.Context, alert util.Alert) error {
+	subject, err := render(s.Subject, alert)
+	if err != nil {
+		return err
+	}
+	body, err := render(s.Template, alert)
+	if err != nil {
+		return err
+	}
+
+	msg := strings.Join([]string{
+		"From: " + s.From,
+		"To: " + strings.Join(s.To, ", "),
+		"Subject: " + strings.NewReplacer("\r", " ", "\n", " ").Replace(subject),
+		"Date: " + alert.Time.Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, _ := net.SplitHostPort(s.Addr)
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+
+	return s.Retry.do(ctx, func() error {
+		if err := s.send(ctx, auth, []byte(msg)); err != nil {
+			return fmt.Errorf("smtp %s: %w", s.Addr, err)
+		}
+		return nil
+	})
+}
+
+// send does what smtp.SendMail does, within ctx and smtpTimeout.
+func (s *SMTP) send(ctx context.Context, auth smtp.Auth, msg []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, smtpTimeout)
+	defer cancel()
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	// Unblock the exchange when ctx is cancelled before the deadline.
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	host, _, _ := net.SplitHostPort(s.Addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("server does not support AUTH")
+		}
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	for _, to := range s.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
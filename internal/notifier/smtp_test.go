@@ -0,0 +1,135 @@
+package notifier
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP accepts a single message without STARTTLS nor AUTH and sends
+// the commands and the data it received on the returned channel.
+func fakeSMTP(t *testing.T) (string, <-chan []string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	received := make(chan []string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		var lines []string
+		defer func() { received <- lines }()
+
+		tp.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			lines = append(lines, line)
+			switch cmd := strings.ToUpper(strings.Fields(line + " ")[0]); cmd {
+			case "EHLO", "HELO", "MAIL", "RCPT":
+				tp.PrintfLine("250 OK")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				data, err := tp.ReadDotLines()
+				if err != nil {
+					return
+				}
+				lines = append(lines, data...)
+				tp.PrintfLine("250 queued")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				return
+			default:
+				tp.PrintfLine("502 unknown command")
+			}
+		}
+	}()
+	return l.Addr().String(), received
+}
+
+func TestSMTP(t *testing.T) {
+	addr, received := fakeSMTP(t)
+	s := &SMTP{
+		Addr:     addr,
+		From:     "monitor@example.com",
+		To:       []string{"a@example.com", "b@example.com"},
+		Subject:  mustTemplate(t, "smtp-subject", "{{ .Rule }}\r\nBcc: evil@example.com"),
+		Template: mustTemplate(t, "smtp", "{{ .Name }} is {{ .State }}"),
+	}
+	if err := s.Notify(context.Background(), testAlert()); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := <-received
+	for _, want := range []string{
+		"MAIL FROM:<monitor@example.com>",
+		"RCPT TO:<a@example.com>",
+		"RCPT TO:<b@example.com>",
+		"To: a@example.com, b@example.com",
+		"Subject: cpu  Bcc: evil@example.com",
+		"web is firing",
+	} {
+		found := false
+		for _, line := range lines {
+			if strings.HasPrefix(line, want) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%q not sent in %q", want, lines)
+		}
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "Bcc:") {
+			t.Errorf("subject injected a header: %q", line)
+		}
+	}
+}
+
+func TestSMTPTimeout(t *testing.T) {
+	// The server accepts connections but never greets.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go bufio.NewReader(conn).ReadString(0)
+		}
+	}()
+
+	s := &SMTP{
+		Addr:     l.Addr().String(),
+		From:     "monitor@example.com",
+		To:       []string{"a@example.com"},
+		Subject:  mustTemplate(t, "smtp-subject", ""),
+		Template: mustTemplate(t, "smtp", ""),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := s.Notify(ctx, testAlert()); err == nil {
+		t.Error("Notify() to a silent server = nil")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Notify() returned after %s despite its context", d)
+	}
+}
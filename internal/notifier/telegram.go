@@ -0,0 +1,47 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/LittleJake/server-monitor-go/internal/util"
+)
+
+// Telegram sends alerts through the Bot API `sendMessage` method.
+// APIURL can point to a local stand-in instead of https://api.telegram.org.
+type Telegram struct {
+	Token    string
+	ChatID   string
+	APIURL   string
+	Template *template.Template
+	Retry    Retry
+	Client   *http.Client
+}
+
+func (t *Telegram) Name() string { return "telegram" }
+
+func (t *Telegram) Notify(ctx context.Context, alert util.Alert) error {
+	text, err := render(t.Template, alert)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"chat_id": t.ChatID,
+		"text":    text,
+	})
+	if err != nil {
+		return err
+	}
+
+	api := strings.TrimRight(t.APIURL, "/")
+	target := fmt.Sprintf("%s/bot%s/sendMessage", api, t.Token)
+	label := "telegram " + redactURL(api)
+	return t.Retry.do(ctx, func() error {
+		return postJSON(ctx, t.Client, target, label, body)
+	})
+}
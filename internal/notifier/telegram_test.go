@@ -0,0 +1,74 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTelegram(t *testing.T) {
+	var path string
+	var payload map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&payload)
+	}))
+	defer srv.Close()
+
+	tg := &Telegram{
+		Token:    "123:token",
+		ChatID:   "-100",
+		APIURL:   srv.URL + "/",
+		Template: mustTemplate(t, "telegram", ""),
+	}
+	if err := tg.Notify(context.Background(), testAlert()); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/bot123:token/sendMessage" {
+		t.Errorf("path = %q", path)
+	}
+	want := "[FIRING] cpu: web Load.user = 95.50 (> 90.00)"
+	if payload["chat_id"] != "-100" || payload["text"] != want {
+		t.Errorf("payload = %v, want text %q", payload, want)
+	}
+}
+
+func TestTelegramErrors(t *testing.T) {
+	tests := map[string]struct {
+		failures     int32
+		status       int
+		wantRequests int32
+	}{
+		"retried":   {10, http.StatusServiceUnavailable, 2},
+		"forbidden": {10, http.StatusForbidden, 1},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv, requests, body := flakyServer(t, tt.failures, tt.status)
+			tg := &Telegram{
+				Token:    "123:token",
+				ChatID:   "-100",
+				APIURL:   srv.URL,
+				Template: mustTemplate(t, "telegram", ""),
+				Retry:    Retry{Attempts: 2, Backoff: time.Millisecond},
+			}
+			err := tg.Notify(context.Background(), testAlert())
+			if err == nil {
+				t.Fatal("Notify() = nil")
+			}
+			if strings.Contains(err.Error(), "token") {
+				t.Errorf("error %q exposes the bot token", err)
+			}
+			if n := requests.Load(); n != tt.wantRequests {
+				t.Errorf("%d requests, want %d", n, tt.wantRequests)
+			}
+			if body.Load() == nil {
+				t.Error("no message was sent")
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"text/template"
+	"time"
+
+	"github.com/LittleJake/server-monitor-go/internal/util"
+)
+
+// Webhook posts alerts as JSON to a generic HTTP endpoint.
+type Webhook struct {
+	URL      string
+	Template *template.Template
+	Retry    Retry
+	Client   *http.Client
+}
+
+func (w *Webhook) Name() string { return "webhook" }
+
+func (w *Webhook) Notify(ctx context.Context, alert util.Alert) error {
+	message, err := render(w.Template, alert)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"uuid":      alert.UUID,
+		"name":      alert.Name,
+		"rule":      alert.Rule,
+		"metric":    alert.Metric,
+		"op":        alert.Op,
+		"threshold": alert.Threshold,
+		"value":     alert.Value,
+		"state":     alert.State,
+		"since":     alert.Since.Unix(),
+		"time":      alert.Time.Unix(),
+		"message":   message,
+	})
+	if err != nil {
+		return err
+	}
+
+	return w.Retry.do(ctx, func() error {
+		return postJSON(ctx, w.Client, w.URL, redactURL(w.URL), body)
+	})
+}
+
+// redactURL keeps the scheme and host of rawURL only, its path and query
+// often hold a secret.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "invalid URL"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// postJSON sends body and treats any non 2xx answer as an error.
+// Client errors other than 429 are not retried. Errors name the target by
+// label, never by its URL which may hold a token.
+func postJSON(ctx context.Context, client *http.Client, target, label string, body []byte) error {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{fmt.Errorf("%s: invalid URL", label)}
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// *url.Error quotes the URL.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return fmt.Errorf("%s: %w", label, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("%s: HTTP %d: %s", label, resp.StatusCode, bytes.TrimSpace(data))
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return permanentError{err}
+	}
+	return err
+}
@@ -0,0 +1,137 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/LittleJake/server-monitor-go/internal/util"
+)
+
+func testAlert() util.Alert {
+	since := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	return util.Alert{
+		UUID:      "n1",
+		Name:      "web",
+		Rule:      "cpu",
+		Metric:    "Load.user",
+		Op:        ">",
+		Threshold: 90,
+		Value:     95.5,
+		State:     "firing",
+		Since:     since,
+		Time:      since.Add(time.Minute),
+	}
+}
+
+func mustTemplate(t *testing.T, name, text string) *template.Template {
+	t.Helper()
+	tmpl, err := NewTemplate(name, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tmpl
+}
+
+// flakyServer answers the first failures requests with status, then 200.
+// It counts every request and keeps the body of the last one.
+func flakyServer(t *testing.T, failures int32, status int) (*httptest.Server, *atomic.Int32, *atomic.Value) {
+	t.Helper()
+	var requests atomic.Int32
+	var last atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		last.Store(body)
+		if requests.Add(1) <= failures {
+			http.Error(w, "try again", status)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests, &last
+}
+
+func TestWebhookPayload(t *testing.T) {
+	var contentType string
+	var payload map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		json.NewDecoder(r.Body).Decode(&payload)
+	}))
+	defer srv.Close()
+
+	w := &Webhook{URL: srv.URL + "/hook", Template: mustTemplate(t, "webhook", "{{ .Name }} is {{ .State | upper }}")}
+	if err := w.Notify(context.Background(), testAlert()); err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "application/json" {
+		t.Errorf("Content-Type = %q", contentType)
+	}
+	want := map[string]any{
+		"uuid": "n1", "name": "web", "rule": "cpu", "metric": "Load.user", "op": ">",
+		"threshold": 90.0, "value": 95.5, "state": "firing",
+		"since": 1767323045.0, "time": 1767323105.0, "message": "web is FIRING",
+	}
+	for k, v := range want {
+		if payload[k] != v {
+			t.Errorf("payload[%s] = %v, want %v", k, payload[k], v)
+		}
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	tests := map[string]struct {
+		failures     int32
+		status       int
+		wantErr      bool
+		wantRequests int32
+	}{
+		"server error then ok": {2, http.StatusBadGateway, false, 3},
+		"rate limited then ok": {1, http.StatusTooManyRequests, false, 2},
+		"always failing":       {10, http.StatusInternalServerError, true, 3},
+		"client error":         {10, http.StatusBadRequest, true, 1},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			srv, requests, _ := flakyServer(t, tt.failures, tt.status)
+			w := &Webhook{
+				URL:      srv.URL + "/hook/secret-path?key=secret",
+				Template: mustTemplate(t, "webhook", ""),
+				Retry:    Retry{Attempts: 3, Backoff: time.Millisecond},
+			}
+			err := w.Notify(context.Background(), testAlert())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Notify() = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && strings.Contains(err.Error(), "secret") {
+				t.Errorf("error %q exposes the URL", err)
+			}
+			if n := requests.Load(); n != tt.wantRequests {
+				t.Errorf("%d requests, want %d", n, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestWebhookUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL + "/hook/secret"
+	srv.Close()
+
+	w := &Webhook{URL: url, Template: mustTemplate(t, "webhook", "")}
+	err := w.Notify(context.Background(), testAlert())
+	if err == nil {
+		t.Fatal("Notify() to a closed server = nil")
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("error %q exposes the URL", err)
+	}
+}
@@ -0,0 +1,328 @@
+package util
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/elliotchance/orderedmap/v3"
+)
+
+// AggregationFuncs lists the supported bucket aggregations.
+var AggregationFuncs = []string{"avg", "min", "max", "last", "p95"}
+
+// Aggregation downsamples a series into buckets of Step seconds.
+// With Step 0 and MaxPoints set, the step is chosen so that at most
+// MaxPoints buckets are returned; series already short enough are untouched.
+type Aggregation struct {
+	Step      int64
+	Func      string
+	MaxPoints int
+}
+
+func ValidAggregation(name string) bool {
+	for _, f := range AggregationFuncs {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+func aggregate(fn string, values []float64) float64 {
+	switch fn {
+	case "min":
+		m := values[0]
+		for _, v := range values[1:] {
+			m = math.Min(m, v)
+		}
+		return m
+	case "max":
+		m := values[0]
+		for _, v := range values[1:] {
+			m = math.Max(m, v)
+		}
+		return m
+	case "last":
+		return values[len(values)-1]
+	case "p95":
+		sorted := append([]float64{}, values...)
+		sort.Float64s(sorted)
+		// nearest-rank percentile
+		return sorted[int(math.Ceil(0.95*float64(len(sorted))))-1]
+	default:
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values))
+	}
+}
+
+// Downsample aggregates the named section of every point into buckets aligned
+// to multiples of the step. Each bucket keeps the non numeric fields (battery
+// status, ...) of its last point.
+func Downsample(collections *orderedmap.OrderedMap[int64, CollectionData], name string, agg Aggregation) *orderedmap.OrderedMap[int64, CollectionData] {
+	if collections == nil || collections.Len() == 0 {
+		return collections
+	}
+
+	step := agg.Step
+	if step <= 0 {
+		if agg.MaxPoints <= 0 || collections.Len() <= agg.MaxPoints {
+			return collections
+		}
+		first, last := collections.Front().Key, collections.Back().Key
+		step = int64(math.Ceil(float64(last-first+1) / float64(agg.MaxPoints)))
+		// Aligned buckets may straddle one more step than the span needs.
+		for last/step-first/step+1 > int64(agg.MaxPoints) {
+			step++
+		}
+	}
+	if step <= 1 && agg.Func == "" {
+		return collections
+	}
+
+	result := orderedmap.NewOrderedMap[int64, CollectionData]()
+	var bucket int64
+	var last CollectionData
+	values := map[string][]float64{}
+
+	flush := func() {
+		if len(values) == 0 {
+			return
+		}
+		point := last.section(name)
+		for key, v := range values {
+			point.set(name, strings.Split(key, "\x00"), aggregate(agg.Func, v))
+		}
+		result.Set(bucket, point)
+		values = map[string][]float64{}
+	}
+
+	for score, collection := range collections.AllFromFront() {
+		if !collection.Has(name) {
+			continue
+		}
+		b := score - score%step
+		if b != bucket {
+			flush()
+			bucket = b
+		}
+		for path, v := range collection.leaves(name) {
+			values[path] = append(values[path], v)
+		}
+		last = collection
+	}
+	flush()
+
+	return result
+}
+
+// leaves flattens the numeric fields of a section, keyed by their path below
+// the section joined with "\x00" since names may contain dots.
+func (c CollectionData) leaves(section string) map[string]float64 {
+	result := map[string]float64{}
+	add := func(v Number, path ...string) {
+		result[strings.Join(path, "\x00")] = v.Float()
+	}
+	memoryStat := func(s MemoryStat, key string) {
+		add(s.Total, key, "total")
+		add(s.Used, key, "used")
+		add(s.Free, key, "free")
+		add(s.Percent, key, "percent")
+	}
+
+	switch section {
+	case "Memory":
+		if c.Memory != nil {
+			memoryStat(c.Memory.Mem, "Mem")
+			memoryStat(c.Memory.Swap, "Swap")
+		}
+	case "Disk":
+		for mountpoint, s := range c.Disk {
+			memoryStat(MemoryStat(s), mountpoint)
+		}
+	case "Network":
+		if c.Network != nil {
+			networkStat := func(s NetworkStat, path ...string) {
+				add(s.Bytes, append(path, "bytes")...)
+				add(s.Packets, append(path, "packets")...)
+			}
+			networkStat(c.Network.RX, "RX")
+			networkStat(c.Network.TX, "TX")
+			for name, i := range c.Network.Interfaces {
+				networkStat(i.RX, "Interfaces", name, "RX")
+				networkStat(i.TX, "Interfaces", name, "TX")
+			}
+		}
+	case "IO":
+		if c.IO != nil {
+			ioStat := func(s IOStat, path ...string) {
+				add(s.Count, append(path, "count")...)
+				add(s.Bytes, append(path, "bytes")...)
+				add(s.Time, append(path, "time")...)
+			}
+			ioStat(c.IO.Read, "read")
+			ioStat(c.IO.Write, "write")
+			for name, dev := range c.IO.Devices {
+				ioStat(dev.Read, "Devices", name, "read")
+				ioStat(dev.Write, "Devices", name, "write")
+			}
+		}
+	case "Load":
+		for key, v := range c.Load {
+			add(v, key)
+		}
+	case "Thermal":
+		for key, v := range c.Thermal {
+			add(v, key)
+		}
+	case "Battery":
+		if c.Battery != nil {
+			add(c.Battery.Percent, "percent")
+			if c.Battery.Secsleft != nil {
+				add(*c.Battery.Secsleft, "secsleft")
+			}
+		}
+	case "Ping":
+		for target, t := range c.Ping {
+			if t.Latency != nil {
+				add(*t.Latency, target, "latency")
+			}
+			add(t.Loss, target, "loss")
+		}
+	}
+	return result
+}
+
+// section returns a copy holding only the named section, safe to modify with set.
+func (c CollectionData) section(name string) CollectionData {
+	result := CollectionData{Version: c.Version}
+	switch name {
+	case "Memory":
+		if c.Memory != nil {
+			m := *c.Memory
+			result.Memory = &m
+		}
+	case "Disk":
+		result.Disk = make(map[string]DiskStat, len(c.Disk))
+		for k, v := range c.Disk {
+			result.Disk[k] = v
+		}
+	case "Network":
+		if c.Network != nil {
+			n := *c.Network
+			n.Interfaces = make(map[string]NetworkInterface, len(c.Network.Interfaces))
+			for k, v := range c.Network.Interfaces {
+				n.Interfaces[k] = v
+			}
+			result.Network = &n
+		}
+	case "IO":
+		if c.IO != nil {
+			io := *c.IO
+			io.Devices = make(map[string]IODevice, len(c.IO.Devices))
+			for k, v := range c.IO.Devices {
+				io.Devices[k] = v
+			}
+			result.IO = &io
+		}
+	case "Load":
+		result.Load = make(map[string]Number, len(c.Load))
+		for k, v := range c.Load {
+			result.Load[k] = v
+		}
+	case "Thermal":
+		result.Thermal = make(map[string]Number, len(c.Thermal))
+		for k, v := range c.Thermal {
+			result.Thermal[k] = v
+		}
+	case "Battery":
+		if c.Battery != nil {
+			b := *c.Battery
+			result.Battery = &b
+		}
+	case "Ping":
+		result.Ping = make(map[string]PingTarget, len(c.Ping))
+		for k, v := range c.Ping {
+			result.Ping[k] = v
+		}
+	}
+	return result
+}
+
+// set stores a value produced by leaves back into its field.
+// Pointer fields are replaced, never written through, so copies made by
+// section do not share them with the source.
+func (c *CollectionData) set(section string, path []string, v float64) {
+	n := Number(v)
+	field := func(s *MemoryStat, name string) {
+		if p := s.field(name); p != nil {
+			*p = n
+		}
+	}
+
+	switch {
+	case section == "Load" && len(path) == 1:
+		c.Load[path[0]] = n
+	case section == "Thermal" && len(path) == 1:
+		c.Thermal[path[0]] = n
+	case section == "Battery" && len(path) == 1 && c.Battery != nil:
+		switch path[0] {
+		case "percent":
+			c.Battery.Percent = n
+		case "secsleft":
+			c.Battery.Secsleft = &n
+		}
+	case section == "Network" && len(path) == 4 && path[0] == "Interfaces" && c.Network != nil:
+		i := c.Network.Interfaces[path[1]]
+		stat := map[string]*NetworkStat{"RX": &i.RX, "TX": &i.TX}[path[2]]
+		if stat != nil && stat.field(path[3]) != nil {
+			*stat.field(path[3]) = n
+			c.Network.Interfaces[path[1]] = i
+		}
+	case section == "IO" && len(path) == 4 && path[0] == "Devices" && c.IO != nil:
+		dev := c.IO.Devices[path[1]]
+		stat := map[string]*IOStat{"read": &dev.Read, "write": &dev.Write}[path[2]]
+		if stat != nil && stat.field(path[3]) != nil {
+			*stat.field(path[3]) = n
+			c.IO.Devices[path[1]] = dev
+		}
+	case len(path) != 2:
+	case section == "Memory" && c.Memory != nil:
+		switch path[0] {
+		case "Mem":
+			field(&c.Memory.Mem, path[1])
+		case "Swap":
+			field(&c.Memory.Swap, path[1])
+		}
+	case section == "Disk":
+		s := MemoryStat(c.Disk[path[0]])
+		field(&s, path[1])
+		c.Disk[path[0]] = DiskStat(s)
+	case section == "Network" && c.Network != nil:
+		switch path[0] {
+		case "RX":
+			*c.Network.RX.field(path[1]) = n
+		case "TX":
+			*c.Network.TX.field(path[1]) = n
+		}
+	case section == "IO" && c.IO != nil:
+		switch path[0] {
+		case "read":
+			*c.IO.Read.field(path[1]) = n
+		case "write":
+			*c.IO.Write.field(path[1]) = n
+		}
+	case section == "Ping":
+		t := c.Ping[path[0]]
+		switch path[1] {
+		case "latency":
+			t.Latency = &n
+		case "loss":
+			t.Loss = n
+		}
+		c.Ping[path[0]] = t
+	}
+}
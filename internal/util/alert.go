@@ -0,0 +1,376 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AlertRule describes a condition evaluated against the latest collection of
+// every node on each CronJob run.
+//
+// Metric is a dotted path into the collection, e.g. "Memory.Mem.percent",
+// "Disk./var.percent", "Thermal.cpu" or "Load.user". The special metric
+// "offline" fires when the node is offline (see IsOnline); its value is the
+// number of seconds since the last update.
+type AlertRule struct {
+	Name      string   `json:"name"`
+	Metric    string   `json:"metric"`
+	Op        string   `json:"op"`
+	Threshold float64  `json:"threshold"`
+	For       string   `json:"for"`
+	UUIDs     []string `json:"uuids,omitempty"`
+
+	duration time.Duration
+}
+
+// AlertState is persisted per node and rule in `system_monitor:alert:<uuid>`.
+type AlertState struct {
+	State      string  `json:"state"`
+	Since      int64   `json:"since"`
+	Value      float64 `json:"value"`
+	ResolvedAt int64   `json:"resolved_at,omitempty"`
+}
+
+const (
+	AlertPending  = "pending"
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// Alert is handed to notifiers on every firing or resolved transition.
+type Alert struct {
+	UUID      string
+	Name      string
+	Rule      string
+	Metric    string
+	Op        string
+	Threshold float64
+	Value     float64
+	State     string
+	Since     time.Time
+	Time      time.Time
+}
+
+func (a Alert) String() string {
+	if a.Metric == "offline" {
+		return fmt.Sprintf("[%s] %s: %s offline for %.0fs", strings.ToUpper(a.State), a.Rule, a.Name, a.Value)
+	}
+	return fmt.Sprintf("[%s] %s: %s %s = %.2f (%s %.2f)", strings.ToUpper(a.State), a.Rule, a.Name, a.Metric, a.Value, a.Op, a.Threshold)
+}
+
+// Notifier delivers alerts, e.g. to a webhook or chat.
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// LogNotifier prints alerts to stdout.
+type LogNotifier struct{}
+
+func (LogNotifier) Name() string { return "log" }
+
+func (LogNotifier) Notify(_ context.Context, alert Alert) error {
+	fmt.Println(alert.String())
+	return nil
+}
+
+var (
+	alertRules []AlertRule
+	notifiers  []Notifier
+	alertMu    sync.RWMutex
+	// notifications tracks the alerts being sent so CronJob can wait for
+	// them on shutdown.
+	notifications sync.WaitGroup
+)
+
+// RegisterNotifier adds a notifier that receives every alert transition.
+func RegisterNotifier(n Notifier) {
+	alertMu.Lock()
+	defer alertMu.Unlock()
+	notifiers = append(notifiers, n)
+}
+
+// LoadAlertRules reads rules as a JSON array from the file named by
+// ALERT_RULES_FILE, or from ALERT_RULES itself.
+func LoadAlertRules() error {
+	data := []byte(Conf().Alert.Rules)
+	if f := Conf().Alert.RulesFile; f != "" {
+		var err error
+		if data, err = os.ReadFile(f); err != nil {
+			return fmt.Errorf("read alert rules: %w", err)
+		}
+	}
+
+	var rules []AlertRule
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &rules); err != nil {
+			return fmt.Errorf("parse alert rules: %w", err)
+		}
+	}
+	for i := range rules {
+		if err := rules[i].validate(); err != nil {
+			return fmt.Errorf("alert rule %d: %w", i, err)
+		}
+	}
+
+	alertMu.Lock()
+	alertRules = rules
+	alertMu.Unlock()
+	return nil
+}
+
+func (r *AlertRule) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if r.Metric == "" {
+		return fmt.Errorf("%s: metric is required", r.Name)
+	}
+	if r.Metric != "offline" {
+		if _, err := compare(r.Op, 0, 0); err != nil {
+			return fmt.Errorf("%s: %w", r.Name, err)
+		}
+	}
+	if r.For != "" {
+		d, err := time.ParseDuration(r.For)
+		if err != nil {
+			return fmt.Errorf("%s: invalid for: %w", r.Name, err)
+		}
+		r.duration = d
+	}
+	return nil
+}
+
+func (r *AlertRule) appliesTo(uuid string) bool {
+	if len(r.UUIDs) == 0 {
+		return true
+	}
+	for _, u := range r.UUIDs {
+		if u == uuid {
+			return true
+		}
+	}
+	return false
+}
+
+func compare(op string, value, threshold float64) (bool, error) {
+	switch op {
+	case ">":
+		return value > threshold, nil
+	case ">=":
+		return value >= threshold, nil
+	case "<":
+		return value < threshold, nil
+	case "<=":
+		return value <= threshold, nil
+	case "==":
+		return value == threshold, nil
+	case "!=":
+		return value != threshold, nil
+	}
+	return false, fmt.Errorf("unsupported op %q", op)
+}
+
+// metricPath splits a rule metric into collection keys. Sections with a
+// nested object per entry (Disk, Memory, Network, IO, Ping) keep dots inside the
+// entry name, so "Disk./mnt/v1.2.percent" resolves to Disk -> /mnt/v1.2 -> percent.
+func metricPath(metric string) []string {
+	section, rest, ok := strings.Cut(metric, ".")
+	if !ok {
+		return []string{section}
+	}
+	switch section {
+	case "Disk", "Memory", "Network", "IO", "Ping":
+		if i := strings.LastIndex(rest, "."); i > 0 {
+			return []string{section, rest[:i], rest[i+1:]}
+		}
+	}
+	return []string{section, rest}
+}
+
+// evaluate returns the current value of the rule metric and whether the
+// condition holds. ok is false when the node has no such metric.
+func (r *AlertRule) evaluate(uuid string, info map[string]string, online bool) (value float64, active bool, ok bool) {
+	if r.Metric == "offline" {
+		t, _ := toFloat64(info["Update Time"])
+		return float64(time.Now().Unix()) - t, !online, true
+	}
+
+	latest, err := GetCollectionLatest(uuid)
+	if err != nil || latest.Empty() {
+		return 0, false, false
+	}
+	value, ok = latest.Lookup(metricPath(r.Metric)...)
+	if !ok {
+		return 0, false, false
+	}
+	active, _ = compare(r.Op, value, r.Threshold)
+	return value, active, true
+}
+
+// GetAlertStates returns the persisted state of every rule of a node.
+func GetAlertStates(uuid string) (map[string]AlertState, error) {
+	return getAlertStates(context.Background(), uuid)
+}
+
+func getAlertStates(ctx context.Context, uuid string) (map[string]AlertState, error) {
+	data, err := DataStore.GetAlertStates(ctx, uuid)
+	if err != nil {
+		return nil, err
+	}
+	states := make(map[string]AlertState, len(data))
+	for rule, v := range data {
+		var s AlertState
+		if err := json.Unmarshal([]byte(v), &s); err == nil {
+			states[rule] = s
+		}
+	}
+	return states, nil
+}
+
+func setAlertState(ctx context.Context, uuid, rule string, s AlertState) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return DataStore.SetAlertState(ctx, uuid, rule, string(data))
+}
+
+func deleteAlertState(ctx context.Context, uuid, rule string) error {
+	return DataStore.DeleteAlertState(ctx, uuid, rule)
+}
+
+func notify(alert Alert) {
+	alertMu.RLock()
+	list := append([]Notifier{}, notifiers...)
+	alertMu.RUnlock()
+
+	for _, n := range list {
+		notifications.Go(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+			defer cancel()
+			if err := n.Notify(ctx, alert); err != nil {
+				fmt.Println("Error sending alert via", n.Name()+":", err)
+			}
+		})
+	}
+}
+
+// EvaluateAlerts runs every rule against every node and notifies on state
+// transitions only: pending -> firing after the rule's `for` duration, and
+// firing -> resolved once the condition clears. Metric rules are left as they
+// are while a node is offline, and the states of removed rules are deleted.
+// A short lived store lock keeps several instances sharing one Redis from
+// evaluating the same run twice.
+//
+// It stops once ctx is done and returns the errors met on the way; the other
+// nodes are still evaluated.
+func EvaluateAlerts(ctx context.Context) error {
+	alertMu.RLock()
+	rules := alertRules
+	alertMu.RUnlock()
+
+	interval := time.Duration(Conf().Data.CronJobInterval) * time.Second
+	if ok, err := DataStore.Claim(ctx, "alert:lock", interval/2); err != nil || !ok {
+		return err
+	}
+
+	uuids, err := GetUUIDs(false)
+	if err != nil {
+		return err
+	}
+	names, _ := getDisplayName(ctx, false)
+	now := time.Now()
+
+	var errs []error
+	for uuid, address := range uuids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info, _ := getInfo(ctx, uuid, false)
+		states, err := getAlertStates(ctx, uuid)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("loading alert states of %s: %w", uuid, err))
+			continue
+		}
+		name := names[uuid]
+		if name == "" {
+			name = address
+		}
+		// The last collection of an offline node is stale, only the offline
+		// rules are evaluated until it reports again.
+		online := isOnline(ctx, uuid, info)
+
+		applied := map[string]bool{}
+		for i := range rules {
+			rule := &rules[i]
+			if !rule.appliesTo(uuid) {
+				continue
+			}
+			applied[rule.Name] = true
+			if rule.Metric != "offline" && !online {
+				continue
+			}
+			value, active, ok := rule.evaluate(uuid, info, online)
+			if !ok {
+				continue
+			}
+
+			state, exists := states[rule.Name]
+			alert := Alert{
+				UUID: uuid, Name: name, Rule: rule.Name, Metric: rule.Metric, Op: rule.Op,
+				Threshold: rule.Threshold, Value: value, Time: now, Since: time.Unix(state.Since, 0),
+			}
+
+			switch {
+			case active && (!exists || state.State == AlertResolved):
+				state = AlertState{State: AlertPending, Since: now.Unix(), Value: value}
+				if rule.duration <= 0 {
+					state.State = AlertFiring
+					alert.State, alert.Since = AlertFiring, now
+					notify(alert)
+				}
+				err = setAlertState(ctx, uuid, rule.Name, state)
+			case active && state.State == AlertPending:
+				state.Value = value
+				if now.Sub(time.Unix(state.Since, 0)) >= rule.duration {
+					state.State = AlertFiring
+					alert.State = AlertFiring
+					notify(alert)
+				}
+				err = setAlertState(ctx, uuid, rule.Name, state)
+			case active:
+				// already firing, deduplicated
+				state.Value = value
+				err = setAlertState(ctx, uuid, rule.Name, state)
+			case exists && state.State == AlertFiring:
+				state.State, state.Value, state.ResolvedAt = AlertResolved, value, now.Unix()
+				alert.State = AlertResolved
+				notify(alert)
+				err = setAlertState(ctx, uuid, rule.Name, state)
+			case exists && state.State == AlertPending:
+				err = deleteAlertState(ctx, uuid, rule.Name)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("saving alert state %s of %s: %w", rule.Name, uuid, err))
+			}
+		}
+
+		// Drop the states of rules removed or no longer applying to the node.
+		for rule := range states {
+			if applied[rule] {
+				continue
+			}
+			if err := deleteAlertState(ctx, uuid, rule); err != nil {
+				errs = append(errs, fmt.Errorf("deleting alert state %s of %s: %w", rule, uuid, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
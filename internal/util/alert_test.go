@@ -0,0 +1,113 @@
+package util
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingNotifier keeps every alert it is sent.
+type recordingNotifier struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (n *recordingNotifier) Name() string { return "recording" }
+
+func (n *recordingNotifier) Notify(ctx context.Context, alert Alert) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.alerts = append(n.alerts, alert)
+	return nil
+}
+
+// setupTestAlerts makes rules current and records the notifications.
+func setupTestAlerts(t *testing.T, rules ...AlertRule) *recordingNotifier {
+	t.Helper()
+	for i := range rules {
+		if err := rules[i].validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n := &recordingNotifier{}
+	alertMu.Lock()
+	oldRules, oldNotifiers := alertRules, notifiers
+	alertRules, notifiers = rules, []Notifier{n}
+	alertMu.Unlock()
+	t.Cleanup(func() {
+		notifications.Wait()
+		alertMu.Lock()
+		alertRules, notifiers = oldRules, oldNotifiers
+		alertMu.Unlock()
+	})
+	return n
+}
+
+// reportLoad saves a report of uuid updated ago seconds ago.
+func reportLoad(t *testing.T, s Store, uuid string, user int, ago int64, alive time.Duration) {
+	t.Helper()
+	ts := time.Now().Unix() - ago
+	err := s.SaveReport(context.Background(), uuid, NodeUpdate{
+		Point:    loadPoint(ts, user),
+		Info:     map[string]string{"Update Time": strconv.FormatInt(ts, 10)},
+		Address:  uuid + ".example",
+		AliveTTL: alive,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEvaluateAlertsOfflineNode(t *testing.T) {
+	s := setupTestStore(t)
+	SetupCollectionCache()
+	n := setupTestAlerts(t,
+		AlertRule{Name: "cpu", Metric: "Load.user", Op: ">", Threshold: 90},
+		AlertRule{Name: "down", Metric: "offline"},
+	)
+	// The last point is below the threshold but the node went offline
+	// while the alert was firing.
+	reportLoad(t, s, "n1", 10, 3600, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	setAlertState(context.Background(), "n1", "cpu", AlertState{State: AlertFiring, Since: 1})
+
+	if err := EvaluateAlerts(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	notifications.Wait()
+
+	states, _ := GetAlertStates("n1")
+	if states["cpu"].State != AlertFiring {
+		t.Errorf("cpu state = %+v, want it left firing while offline", states["cpu"])
+	}
+	if states["down"].State != AlertFiring {
+		t.Errorf("down state = %+v, want firing", states["down"])
+	}
+	if len(n.alerts) != 1 || n.alerts[0].Rule != "down" {
+		t.Errorf("notified %+v, want the offline rule only", n.alerts)
+	}
+}
+
+func TestEvaluateAlertsPrunesStates(t *testing.T) {
+	s := setupTestStore(t)
+	SetupCollectionCache()
+	setupTestAlerts(t,
+		AlertRule{Name: "cpu", Metric: "Load.user", Op: ">", Threshold: 90},
+		AlertRule{Name: "other", Metric: "Load.user", Op: ">", Threshold: 90, UUIDs: []string{"n2"}},
+	)
+	reportLoad(t, s, "n1", 95, 0, time.Minute)
+	for _, rule := range []string{"cpu", "removed", "other"} {
+		setAlertState(context.Background(), "n1", rule, AlertState{State: AlertFiring, Since: 1})
+	}
+
+	if err := EvaluateAlerts(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	states, _ := GetAlertStates("n1")
+	if _, ok := states["cpu"]; !ok || len(states) != 1 {
+		t.Errorf("states = %v, want only cpu", states)
+	}
+}
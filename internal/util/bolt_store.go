@@ -0,0 +1,516 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltHashes = []byte("hashes")
+	boltSeries = []byte("series")
+	boltKeys   = []byte("keys")
+)
+
+// BoltStore is an embedded Store in a single bbolt file, for deployments
+// without a Redis server. It mirrors the Redis layout: a bucket per hash
+// (hashes, name, info:<uuid>, ...) and per series (collection:<uuid>, ...),
+// plus expiring keys. Events are only delivered within the process.
+type BoltStore struct {
+	db     *bolt.DB
+	events *localBus
+	done   chan struct{}
+	closed sync.Once
+}
+
+// OpenBoltStore opens or creates the database at path.
+func OpenBoltStore(path string) (*BoltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("create bolt directory: %w", err)
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open bolt %q: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltHashes, boltSeries, boltKeys} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("init bolt %q: %w", path, err)
+	}
+
+	s := &BoltStore{db: db, events: newLocalBus(), done: make(chan struct{})}
+	go s.sweep(time.Minute)
+	return s, nil
+}
+
+// sweep drops expired keys, which are otherwise only ignored when read.
+func (s *BoltStore) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			now := time.Now()
+			err := s.db.Update(func(tx *bolt.Tx) error {
+				b := tx.Bucket(boltKeys)
+				expired := [][]byte{}
+				b.ForEach(func(k, v []byte) error {
+					if _, ok := boltValue(v, now); !ok {
+						expired = append(expired, k)
+					}
+					return nil
+				})
+				return deleteKeys(b, expired)
+			})
+			if err != nil {
+				fmt.Println("Error sweeping bolt keys:", err)
+			}
+		}
+	}
+}
+
+// boltValue decodes an expiring key stored as its expiration in unix
+// nanoseconds (0 for none) followed by the value.
+func boltValue(v []byte, now time.Time) ([]byte, bool) {
+	if len(v) < 8 {
+		return nil, false
+	}
+	if exp := int64(binary.BigEndian.Uint64(v)); exp != 0 && exp <= now.UnixNano() {
+		return nil, false
+	}
+	return v[8:], true
+}
+
+func putBoltValue(b *bolt.Bucket, key string, value []byte, ttl time.Duration) error {
+	v := make([]byte, 8, 8+len(value))
+	if ttl > 0 {
+		binary.BigEndian.PutUint64(v, uint64(time.Now().Add(ttl).UnixNano()))
+	}
+	return b.Put([]byte(key), append(v, value...))
+}
+
+// pointKey orders points by time, then by insertion.
+func pointKey(t int64, seq uint64) []byte {
+	k := make([]byte, 16)
+	binary.BigEndian.PutUint64(k, uint64(max(t, 0)))
+	binary.BigEndian.PutUint64(k[8:], seq)
+	return k
+}
+
+func pointTime(k []byte) int64 {
+	return int64(binary.BigEndian.Uint64(k))
+}
+
+func (s *BoltStore) hgetall(name string) (map[string]string, error) {
+	result := map[string]string{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltHashes).Bucket([]byte(name))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			result[string(k)] = string(v)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("bolt read %q: %w", name, err)
+	}
+	return result, nil
+}
+
+func (s *BoltStore) hget(name, field string) (string, error) {
+	var value string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(boltHashes).Bucket([]byte(name)); b != nil {
+			value = string(b.Get([]byte(field)))
+		}
+		return nil
+	})
+	return value, err
+}
+
+func hset(tx *bolt.Tx, name string, values map[string]string) error {
+	b, err := tx.Bucket(boltHashes).CreateBucketIfNotExists([]byte(name))
+	if err != nil {
+		return err
+	}
+	for k, v := range values {
+		if err := b.Put([]byte(k), []byte(v)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hdel(tx *bolt.Tx, name, field string) error {
+	if b := tx.Bucket(boltHashes).Bucket([]byte(name)); b != nil {
+		return b.Delete([]byte(field))
+	}
+	return nil
+}
+
+func (s *BoltStore) hset(name, field, value string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return hset(tx, name, map[string]string{field: value})
+	})
+	if err != nil {
+		return fmt.Errorf("bolt write %q: %w", name, err)
+	}
+	return nil
+}
+
+func (s *BoltStore) hdel(name, field string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return hdel(tx, name, field)
+	})
+	if err != nil {
+		return fmt.Errorf("bolt delete %q %q: %w", name, field, err)
+	}
+	return nil
+}
+
+func appendPoints(tx *bolt.Tx, series, uuid string, points ...Point) error {
+	b, err := tx.Bucket(boltSeries).CreateBucketIfNotExists([]byte(series + ":" + uuid))
+	if err != nil {
+		return err
+	}
+	for _, p := range points {
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		if err := b.Put(pointKey(p.Time, seq), []byte(p.Data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteKeys removes keys collected beforehand, deleting while iterating
+// with a cursor skips entries.
+func deleteKeys(b *bolt.Bucket, keys [][]byte) error {
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteBucket(parent *bolt.Bucket, name string) error {
+	if err := parent.DeleteBucket([]byte(name)); err != nil && err != bolt.ErrBucketNotFound {
+		return err
+	}
+	return nil
+}
+
+func (s *BoltStore) ListNodes(ctx context.Context) (map[string]string, error) {
+	return s.hgetall("hashes")
+}
+
+func (s *BoltStore) GetInfo(ctx context.Context, uuid string) (map[string]string, error) {
+	return s.hgetall("info:" + uuid)
+}
+
+func (s *BoltStore) IsAlive(ctx context.Context, uuid string) (bool, error) {
+	alive := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		_, alive = boltValue(tx.Bucket(boltKeys).Get([]byte("alive:"+uuid)), time.Now())
+		return nil
+	})
+	return alive, err
+}
+
+func (s *BoltStore) SaveReport(ctx context.Context, uuid string, u NodeUpdate) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := appendPoints(tx, SeriesCollection, uuid, u.Point); err != nil {
+			return err
+		}
+		if len(u.Info) > 0 {
+			if err := hset(tx, "info:"+uuid, u.Info); err != nil {
+				return err
+			}
+		}
+		if err := hset(tx, "hashes", map[string]string{uuid: u.Address}); err != nil {
+			return err
+		}
+		return putBoltValue(tx.Bucket(boltKeys), "alive:"+uuid, []byte(strconv.FormatInt(u.Point.Time, 10)), u.AliveTTL)
+	})
+	if err != nil {
+		return fmt.Errorf("bolt save report %q: %w", uuid, err)
+	}
+	return nil
+}
+
+func (s *BoltStore) DeleteNode(ctx context.Context, uuid string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		series := tx.Bucket(boltSeries)
+		if err := deleteBucket(series, SeriesCollection+":"+uuid); err != nil {
+			return err
+		}
+		for _, tier := range RollupTiers {
+			if err := deleteBucket(series, tier.Series()+":"+uuid); err != nil {
+				return err
+			}
+		}
+
+		hashes := tx.Bucket(boltHashes)
+		for _, name := range []string{"info:" + uuid, "alert:" + uuid} {
+			if err := deleteBucket(hashes, name); err != nil {
+				return err
+			}
+		}
+		for _, key := range nodeHashes {
+			if err := hdel(tx, strings.TrimPrefix(key, "system_monitor:"), uuid); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(boltKeys).Delete([]byte("alive:" + uuid))
+	})
+	if err != nil {
+		return fmt.Errorf("bolt delete node %q: %w", uuid, err)
+	}
+	return nil
+}
+
+func (s *BoltStore) Purge(ctx context.Context) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, key := range nodeHashes {
+			if err := deleteBucket(tx.Bucket(boltHashes), strings.TrimPrefix(key, "system_monitor:")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltStore) GetNames(ctx context.Context) (map[string]string, error) {
+	return s.hgetall("name")
+}
+
+func (s *BoltStore) SetName(ctx context.Context, uuid, name string) error {
+	if name == "" {
+		return s.hdel("name", uuid)
+	}
+	return s.hset("name", uuid, name)
+}
+
+func (s *BoltStore) GetHidden(ctx context.Context) (map[string]string, error) {
+	return s.hgetall("hide")
+}
+
+func (s *BoltStore) SetHidden(ctx context.Context, uuid string, hidden bool) error {
+	if hidden {
+		return s.hset("hide", uuid, "1")
+	}
+	return s.hdel("hide", uuid)
+}
+
+func (s *BoltStore) GetStatus(ctx context.Context, uuid string) (string, error) {
+	return s.hget("status", uuid)
+}
+
+func (s *BoltStore) SetStatus(ctx context.Context, uuid, status string) error {
+	return s.hset("status", uuid, status)
+}
+
+func (s *BoltStore) GetToken(ctx context.Context, uuid string) (string, error) {
+	return s.hget("token", uuid)
+}
+
+func (s *BoltStore) SetToken(ctx context.Context, uuid, token string) error {
+	return s.hset("token", uuid, token)
+}
+
+func (s *BoltStore) GetAlertStates(ctx context.Context, uuid string) (map[string]string, error) {
+	return s.hgetall("alert:" + uuid)
+}
+
+func (s *BoltStore) SetAlertState(ctx context.Context, uuid, rule, state string) error {
+	return s.hset("alert:"+uuid, rule, state)
+}
+
+func (s *BoltStore) DeleteAlertState(ctx context.Context, uuid, rule string) error {
+	return s.hdel("alert:"+uuid, rule)
+}
+
+func (s *BoltStore) AppendPoints(ctx context.Context, series, uuid string, points ...Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return appendPoints(tx, series, uuid, points...)
+	})
+	if err != nil {
+		return fmt.Errorf("bolt append %s:%s: %w", series, uuid, err)
+	}
+	return nil
+}
+
+func (s *BoltStore) RangePoints(ctx context.Context, series, uuid string, start, end int64) ([]Point, error) {
+	points := []Point{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltSeries).Bucket([]byte(series + ":" + uuid))
+		if b == nil || end < 0 {
+			return nil
+		}
+		c := b.Cursor()
+		max := pointKey(end+1, 0)
+		for k, v := c.Seek(pointKey(start, 0)); k != nil && bytes.Compare(k, max) < 0; k, v = c.Next() {
+			points = append(points, Point{Time: pointTime(k), Data: string(v)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("bolt range %s:%s: %w", series, uuid, err)
+	}
+	return points, nil
+}
+
+func (s *BoltStore) LastPoint(ctx context.Context, series, uuid string, before int64) (*Point, error) {
+	var point *Point
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltSeries).Bucket([]byte(series + ":" + uuid))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		k, v := c.Last()
+		if before > 0 {
+			if k, _ = c.Seek(pointKey(before, 0)); k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+		}
+		if k != nil {
+			point = &Point{Time: pointTime(k), Data: string(v)}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("bolt last %s:%s: %w", series, uuid, err)
+	}
+	return point, nil
+}
+
+func (s *BoltStore) CountPoints(ctx context.Context, series, uuid string) (int64, error) {
+	var n int64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(boltSeries).Bucket([]byte(series + ":" + uuid)); b != nil {
+			n = int64(b.Stats().KeyN)
+		}
+		return nil
+	})
+	return n, err
+}
+
+func (s *BoltStore) Retain(ctx context.Context, series, uuid string, cutoff int64) error {
+	if cutoff < 0 {
+		return nil
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltSeries).Bucket([]byte(series + ":" + uuid))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		max := pointKey(cutoff+1, 0)
+		expired := [][]byte{}
+		for k, _ := c.First(); k != nil && bytes.Compare(k, max) < 0; k, _ = c.Next() {
+			expired = append(expired, k)
+		}
+		return deleteKeys(b, expired)
+	})
+	if err != nil {
+		return fmt.Errorf("bolt retain %s:%s: %w", series, uuid, err)
+	}
+	return nil
+}
+
+func (s *BoltStore) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	claimed := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltKeys)
+		if _, ok := boltValue(b.Get([]byte(key)), time.Now()); ok {
+			return nil
+		}
+		claimed = true
+		return putBoltValue(b, key, []byte("1"), ttl)
+	})
+	if err != nil {
+		return false, fmt.Errorf("bolt claim %q: %w", key, err)
+	}
+	return claimed, nil
+}
+
+func (s *BoltStore) GetCounter(ctx context.Context, key string) (int64, error) {
+	var n int64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if v, ok := boltValue(tx.Bucket(boltKeys).Get([]byte(key)), time.Now()); ok {
+			n, _ = strconv.ParseInt(string(v), 10, 64)
+		}
+		return nil
+	})
+	return n, err
+}
+
+func (s *BoltStore) IncrCounter(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	var n int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltKeys)
+		if v, ok := boltValue(b.Get([]byte(key)), time.Now()); ok {
+			n, _ = strconv.ParseInt(string(v), 10, 64)
+		}
+		n++
+		return putBoltValue(b, key, []byte(strconv.FormatInt(n, 10)), ttl)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("bolt incr %q: %w", key, err)
+	}
+	return n, nil
+}
+
+func (s *BoltStore) ResetCounter(ctx context.Context, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltKeys).Delete([]byte(key))
+	})
+}
+
+func (s *BoltStore) Publish(ctx context.Context, channel string, payload []byte) error {
+	s.events.publish(channel, payload)
+	return nil
+}
+
+func (s *BoltStore) Subscribe(ctx context.Context, channel string, handler func(payload []byte)) error {
+	s.events.subscribe(ctx, channel, handler)
+	return nil
+}
+
+func (s *BoltStore) Close() error {
+	var err error
+	s.closed.Do(func() {
+		close(s.done)
+		err = s.db.Close()
+	})
+	return err
+}
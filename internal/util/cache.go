@@ -4,23 +4,32 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
+	"time"
 
 	"github.com/elliotchance/orderedmap/v3"
 	"github.com/karlseguin/ccache/v3"
 	"github.com/peterbourgon/diskv/v3"
-	"github.com/redis/go-redis/v9"
 )
 
-var CollectionCache *ccache.Cache[*orderedmap.OrderedMap[int64, CollectionData]]
+// CollectionCache holds the raw collection of each node, keyed by
+// "system_monitor:collection:<uuid>".
+var CollectionCache *CollectionLRU
 var CollectionStatusCache *ccache.Cache[*orderedmap.OrderedMap[string, CollectionData]]
 var MapStringCache *ccache.Cache[map[string]string]
 
 var DiskCache *diskv.Diskv
 
+// SetupCollectionCache keeps at most COLLECTION_CACHE_SIZE points (default:
+// 100000) in memory, evicting the least recently used nodes first.
 func SetupCollectionCache() {
-	CollectionCache = ccache.New(ccache.Configure[*orderedmap.OrderedMap[int64, CollectionData]]())
+	CollectionCache = NewCollectionLRU(int64(Conf().Cache.CollectionSize))
 }
 
 func SetupCollectionStatusCache() {
@@ -31,36 +40,61 @@ func SetupMapStringCache() {
 	MapStringCache = ccache.New(ccache.Configure[map[string]string]())
 }
 
+// diskCacheDir is the subdirectory of DISK_CACHE_PATH holding the entries, so
+// that sweeping it never touches files of others.
+const diskCacheDir = "collections"
+
+// diskCacheKey matches the names of the entries, see toHash.
+var diskCacheKey = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// SetupDiskCache stores entries gzipped under DISK_CACHE_PATH/collections
+// (default: ./cache/collections). They expire DISK_CACHE_TTL seconds (default: 86400) after being
+// written and SweepDiskCache keeps them within DISK_CACHE_SIZE_MB (default:
+// 256, 0 means unbounded). diskv keeps no copy in memory, CollectionCache does.
 func SetupDiskCache() {
 	DiskCache = diskv.New(diskv.Options{
-		BasePath: "./cache/",
-		// Transform: func(s string) []string {
-		// 	return []string{toHash(s)[:2]}
-		// },
+		BasePath:     filepath.Join(Conf().Cache.DiskPath, diskCacheDir),
 		CacheSizeMax: 0,
 		Compression:  diskv.NewGzipCompression(),
 	})
 }
 
+func diskCacheTTL() time.Duration {
+	return time.Duration(Conf().Cache.DiskTTL) * time.Second
+}
+
 func toHash(s string) string {
 	sha256sum := sha256.Sum256([]byte(s))
 	result := hex.EncodeToString(sha256sum[:])
 	return result
 }
 
-func SetDiskCacheRedisZ(key string, value []redis.Z) error {
+// diskCacheEntry is the file written by SetDiskCachePoints.
+type diskCacheEntry struct {
+	Created int64            `json:"created"`
+	Points  []diskCachePoint `json:"points"`
+}
+
+type diskCachePoint struct {
+	Score  string
+	Member string
+}
+
+func SetDiskCachePoints(key string, value []Point) error {
 	key = toHash(key)
 
-	result := make([]map[string]interface{}, 0)
+	entry := diskCacheEntry{
+		Created: time.Now().Unix(),
+		Points:  make([]diskCachePoint, 0, len(value)),
+	}
 	for _, item := range value {
-		t := map[string]interface{}{
-			"Score":  strconv.FormatFloat(item.Score, 'f', -1, 64),
-			"Member": item.Member,
-		}
-		result = append(result, t)
+		entry.Points = append(entry.Points, diskCachePoint{
+			Score:  strconv.FormatInt(item.Time, 10),
+			Member: item.Data,
+		})
 	}
 
-	data, err := json.Marshal(result)
+	data, err := json.Marshal(entry)
 	if err != nil {
 		fmt.Println(err)
 		return err
@@ -68,28 +102,111 @@ func SetDiskCacheRedisZ(key string, value []redis.Z) error {
 	return DiskCache.Write(key, data)
 }
 
-func GetDiskCacheRedisZ(key string) ([]redis.Z, error) {
+// GetDiskCachePoints returns the points cached under key. Expired and
+// unreadable entries, including those of older versions, are erased.
+func GetDiskCachePoints(key string) ([]Point, error) {
 	key = toHash(key)
 
 	data, err := DiskCache.Read(key)
 	if err != nil {
-		fmt.Println(err)
+		if !os.IsNotExist(err) {
+			fmt.Println(err)
+		}
 		return nil, err
 	}
 
-	var m []map[string]interface{}
+	var entry diskCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		_ = DiskCache.Erase(key)
+		return nil, fmt.Errorf("invalid disk cache entry %s: %w", key, err)
+	}
+	if ttl := diskCacheTTL(); ttl > 0 && time.Since(time.Unix(entry.Created, 0)) > ttl {
+		_ = DiskCache.Erase(key)
+		return nil, fmt.Errorf("disk cache entry %s expired", key)
+	}
+
+	result := make([]Point, 0, len(entry.Points))
+	for _, item := range entry.Points {
+		v, _ := strconv.ParseInt(item.Score, 10, 64)
+		result = append(result, Point{Time: v, Data: item.Member})
+	}
+	return result, nil
+}
+
+func DeleteDiskCache(key string) {
+	if DiskCache == nil {
+		return
+	}
+	_ = DiskCache.Erase(toHash(key))
+}
 
-	result := make([]redis.Z, 0)
+// SweepDiskCache erases the entries of nodes missing from uuids, the expired
+// ones and, oldest first, those beyond DISK_CACHE_SIZE_MB.
+// It returns the number of erased entries and the errors of those it could
+// not erase.
+func SweepDiskCache(uuids map[string]string) (int, error) {
+	if DiskCache == nil {
+		return 0, nil
+	}
 
-	json.Unmarshal(data, &m)
+	live := make(map[string]bool, len(uuids))
+	for uuid := range uuids {
+		live[toHash("system_monitor:collection:"+uuid)] = true
+	}
 
-	for _, item := range m {
-		z := new(redis.Z)
-		v, _ := toFloat64(item["Score"])
-		z.Score = v
-		z.Member = item["Member"]
+	type entry struct {
+		key      string
+		size     int64
+		modified time.Time
+	}
+	var entries []entry
+	for key := range DiskCache.Keys(nil) {
+		// Only entries written by SetDiskCachePoints are erased.
+		if !diskCacheKey.MatchString(key) {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(DiskCache.BasePath, key))
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry{key, info.Size(), info.ModTime()})
+	}
 
-		result = append(result, *z)
+	ttl := diskCacheTTL()
+	erased := 0
+	var errs []error
+	kept := entries[:0]
+	var size int64
+	for _, e := range entries {
+		// Files are rewritten as a whole, so their modification time is
+		// the creation time of the entry.
+		if !live[e.key] || (ttl > 0 && time.Since(e.modified) > ttl) {
+			if err := DiskCache.Erase(e.key); err != nil {
+				errs = append(errs, err)
+			} else {
+				erased++
+			}
+			continue
+		}
+		kept = append(kept, e)
+		size += e.size
 	}
-	return result, nil
+
+	maxSize := int64(Conf().Cache.DiskSizeMB) << 20
+	if maxSize <= 0 {
+		return erased, errors.Join(errs...)
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].modified.Before(kept[j].modified) })
+	for _, e := range kept {
+		if size <= maxSize {
+			break
+		}
+		if err := DiskCache.Erase(e.key); err != nil {
+			errs = append(errs, err)
+		} else {
+			erased++
+		}
+		size -= e.size
+	}
+	return erased, errors.Join(errs...)
 }
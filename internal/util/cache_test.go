@@ -0,0 +1,180 @@
+package util
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/peterbourgon/diskv/v3"
+)
+
+func setupTestDiskCache(t *testing.T) {
+	t.Helper()
+	old := DiskCache
+	DiskCache = diskv.New(diskv.Options{
+		BasePath:    t.TempDir(),
+		Compression: diskv.NewGzipCompression(),
+	})
+	t.Cleanup(func() { DiskCache = old })
+}
+
+func TestDiskCachePoints(t *testing.T) {
+	setupTestDiskCache(t)
+
+	points := []Point{
+		{Time: 1700000000, Data: `{"Load":{"user":1}}`},
+		{Time: 1700000060, Data: `{"Load":{"user":"2.50"}}`},
+	}
+	if err := SetDiskCachePoints("system_monitor:collection:n1", points); err != nil {
+		t.Fatal(err)
+	}
+	got, err := GetDiskCachePoints("system_monitor:collection:n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, points) {
+		t.Errorf("GetDiskCachePoints() = %v, want %v", got, points)
+	}
+
+	// Keys are hashed, so any key is a valid file name.
+	if !DiskCache.Has(toHash("system_monitor:collection:n1")) {
+		t.Error("entry not stored under the hash of its key")
+	}
+
+	DeleteDiskCache("system_monitor:collection:n1")
+	if _, err := GetDiskCachePoints("system_monitor:collection:n1"); err == nil {
+		t.Error("GetDiskCachePoints() after delete returned no error")
+	}
+}
+
+func TestDiskCacheEmpty(t *testing.T) {
+	setupTestDiskCache(t)
+
+	if _, err := GetDiskCachePoints("missing"); err == nil {
+		t.Error("GetDiskCachePoints() of a missing key returned no error")
+	}
+	// An empty series is cached as such rather than as a miss.
+	if err := SetDiskCachePoints("empty", nil); err != nil {
+		t.Fatal(err)
+	}
+	got, err := GetDiskCachePoints("empty")
+	if err != nil || len(got) != 0 {
+		t.Errorf("GetDiskCachePoints() = %v, %v; want empty", got, err)
+	}
+}
+
+func TestDeleteDiskCacheUninitialized(t *testing.T) {
+	old := DiskCache
+	DiskCache = nil
+	defer func() { DiskCache = old }()
+
+	DeleteDiskCache("anything")
+}
+
+func TestSetupDiskCachePath(t *testing.T) {
+	old := DiskCache
+	defer func() { DiskCache = old }()
+	dir := t.TempDir()
+	setTestConfig(t, func(c *Config) { c.Cache.DiskPath = dir })
+	SetupDiskCache()
+
+	if err := SetDiskCachePoints("key", []Point{{1, "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "collections", toHash("key"))); err != nil {
+		t.Errorf("entry not written under DISK_CACHE_PATH: %v", err)
+	}
+}
+
+func TestDiskCacheInvalidEntries(t *testing.T) {
+	setupTestDiskCache(t)
+	setTestConfig(t, func(c *Config) { c.Cache.DiskTTL = 60 })
+
+	tests := map[string]string{
+		"expired": `{"created":1,"points":[{"Score":"1","Member":"a"}]}`,
+		"legacy":  `[{"Score":"1","Member":"a"}]`,
+		"corrupt": `{`,
+	}
+	for name, data := range tests {
+		if err := DiskCache.Write(toHash(name), []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := GetDiskCachePoints(name); err == nil {
+			t.Errorf("GetDiskCachePoints(%s) = %v, want an error", name, got)
+		}
+		if DiskCache.Has(toHash(name)) {
+			t.Errorf("%s entry was not erased", name)
+		}
+	}
+
+	// DISK_CACHE_TTL=0 disables the expiration.
+	setTestConfig(t, func(c *Config) { c.Cache.DiskTTL = 0 })
+	DiskCache.Write(toHash("expired"), []byte(tests["expired"]))
+	if _, err := GetDiskCachePoints("expired"); err != nil {
+		t.Errorf("GetDiskCachePoints() without TTL: %v", err)
+	}
+}
+
+func TestSweepDiskCache(t *testing.T) {
+	setupTestDiskCache(t)
+	setTestConfig(t, func(c *Config) { c.Cache.DiskTTL = 3600 })
+	key := func(uuid string) string { return "system_monitor:collection:" + uuid }
+	age := func(uuid string, d time.Duration) {
+		modified := time.Now().Add(-d)
+		if err := os.Chtimes(filepath.Join(DiskCache.BasePath, toHash(key(uuid))), modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, uuid := range []string{"n1", "deleted", "expired"} {
+		SetDiskCachePoints(key(uuid), []Point{{1, "a"}})
+	}
+	age("expired", 2*time.Hour)
+
+	// Files that are not entries are never erased, however old.
+	foreign := []string{".env", "monitor.db", strings.Repeat("A", 64)}
+	for _, name := range foreign {
+		path := filepath.Join(DiskCache.BasePath, name)
+		if err := os.WriteFile(path, []byte("keep"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, time.Unix(1, 0), time.Unix(1, 0))
+	}
+
+	uuids := map[string]string{"n1": "", "expired": ""}
+	if n, err := SweepDiskCache(uuids); n != 2 || err != nil {
+		t.Errorf("SweepDiskCache() = %d, %v; want 2, nil", n, err)
+	}
+	for uuid, want := range map[string]bool{"n1": true, "deleted": false, "expired": false} {
+		if got := DiskCache.Has(toHash(key(uuid))); got != want {
+			t.Errorf("%s kept = %v, want %v", uuid, got, want)
+		}
+	}
+	for _, name := range foreign {
+		if _, err := os.Stat(filepath.Join(DiskCache.BasePath, name)); err != nil {
+			t.Errorf("%s was erased: %v", name, err)
+		}
+	}
+
+	// Two incompressible entries of about 0.75MB exceed DISK_CACHE_SIZE_MB=1,
+	// the oldest one is erased.
+	setTestConfig(t, func(c *Config) { c.Cache.DiskSizeMB = 1 })
+	big := make([]byte, 750<<10)
+	for _, uuid := range []string{"n1", "n2"} {
+		rand.Read(big)
+		SetDiskCachePoints(key(uuid), []Point{{1, hex.EncodeToString(big)}})
+	}
+	age("n1", time.Minute)
+	uuids["n2"] = ""
+	if n, err := SweepDiskCache(uuids); n != 1 || err != nil {
+		t.Errorf("SweepDiskCache() over size = %d, %v; want 1, nil", n, err)
+	}
+	if DiskCache.Has(toHash(key("n1"))) || !DiskCache.Has(toHash(key("n2"))) {
+		t.Error("the newest entry was erased instead of the oldest")
+	}
+}
@@ -0,0 +1,625 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CollectionVersion is the newest collection schema this server understands.
+// Agents may send it as "Version"; payloads without it are version 1.
+const CollectionVersion = 1
+
+// Number is a float64 that also decodes from numeric strings, agents send
+// sizes as "1024.00" and percentages as plain numbers.
+type Number float64
+
+func (n *Number) UnmarshalJSON(b []byte) error {
+	f, err := parseNumber(b)
+	if err != nil {
+		return err
+	}
+	*n = Number(f)
+	return nil
+}
+
+// MarshalJSON also keeps html/template from quoting Number via String in scripts.
+func (n Number) MarshalJSON() ([]byte, error) {
+	return []byte(n.String()), nil
+}
+
+func (n Number) Float() float64 {
+	return float64(n)
+}
+
+func (n Number) String() string {
+	return strconv.FormatFloat(float64(n), 'f', -1, 64)
+}
+
+func parseNumber(b []byte) (float64, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return 0, err
+	}
+
+	var s string
+	switch val := v.(type) {
+	case json.Number:
+		s = val.String()
+	case string:
+		s = strings.TrimSpace(val)
+	default:
+		return 0, fmt.Errorf("expected number, got %s", jsonKind(v))
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("expected number, got %q", s)
+	}
+	return f, nil
+}
+
+func jsonKind(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number, float64:
+		return "number"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+type MemoryStat struct {
+	Total   Number `json:"total"`
+	Used    Number `json:"used"`
+	Free    Number `json:"free"`
+	Percent Number `json:"percent"`
+}
+
+type Memory struct {
+	Mem  MemoryStat `json:"Mem"`
+	Swap MemoryStat `json:"Swap"`
+}
+
+// DiskStat sizes are in megabytes, like MemoryStat.
+type DiskStat MemoryStat
+
+type NetworkStat struct {
+	Bytes   Number `json:"bytes"`
+	Packets Number `json:"packets"`
+}
+
+type Network struct {
+	RX NetworkStat `json:"RX"`
+	TX NetworkStat `json:"TX"`
+	// Interfaces optionally breaks the totals down by interface name.
+	Interfaces map[string]NetworkInterface `json:"Interfaces,omitempty"`
+}
+
+type NetworkInterface struct {
+	RX NetworkStat `json:"RX"`
+	TX NetworkStat `json:"TX"`
+}
+
+type IOStat struct {
+	Count Number `json:"count"`
+	Bytes Number `json:"bytes"`
+	Time  Number `json:"time"`
+}
+
+type IO struct {
+	Read  IOStat `json:"read"`
+	Write IOStat `json:"write"`
+	// Devices optionally breaks the totals down by block device name.
+	Devices map[string]IODevice `json:"Devices,omitempty"`
+}
+
+type IODevice struct {
+	Read  IOStat `json:"read"`
+	Write IOStat `json:"write"`
+}
+
+// Battery follows psutil.sensors_battery(). Only Percent is required.
+type Battery struct {
+	Percent      Number  `json:"percent"`
+	PowerPlugged *bool   `json:"power_plugged,omitempty"`
+	Charging     *bool   `json:"charging,omitempty"`
+	Secsleft     *Number `json:"secsleft,omitempty"`
+	Status       string  `json:"status,omitempty"`
+}
+
+// State returns the charge state: the reported status, or one derived from
+// charging/power_plugged. It is empty when nothing is known.
+func (b *Battery) State() string {
+	switch {
+	case b.Status != "":
+		return strings.ToLower(b.Status)
+	case b.Charging != nil && *b.Charging:
+		return "charging"
+	case b.Charging != nil || (b.PowerPlugged != nil && !*b.PowerPlugged):
+		return "discharging"
+	case b.PowerPlugged != nil && b.Percent >= 100:
+		return "full"
+	case b.PowerPlugged != nil:
+		return "charging"
+	}
+	return ""
+}
+
+// PingTarget holds the probes of one target. Latency is nil when every probe was lost.
+type PingTarget struct {
+	Latency *Number `json:"latency"`
+	Loss    Number  `json:"loss"`
+}
+
+// CollectionData is one point reported by an agent, see the README for the schema.
+type CollectionData struct {
+	Version int                   `json:"Version,omitempty"`
+	Disk    map[string]DiskStat   `json:"Disk,omitempty"`
+	Memory  *Memory               `json:"Memory,omitempty"`
+	Load    map[string]Number     `json:"Load,omitempty"`
+	Network *Network              `json:"Network,omitempty"`
+	Thermal map[string]Number     `json:"Thermal,omitempty"`
+	Battery *Battery              `json:"Battery,omitempty"`
+	IO      *IO                   `json:"IO,omitempty"`
+	Ping    map[string]PingTarget `json:"Ping,omitempty"`
+}
+
+// DecodeCollection decodes an agent payload. Fields that are missing or of the
+// wrong type are reported in a *ValidationError; the rest is still decoded.
+// Unknown sections are ignored.
+func DecodeCollection(data []byte) (CollectionData, error) {
+	d := &collectionDecoder{verr: &ValidationError{}}
+	c := d.collection("Collection", data)
+	if len(d.verr.Fields) > 0 {
+		sort.Strings(d.verr.Fields)
+		return c, d.verr
+	}
+	return c, nil
+}
+
+// UnmarshalJSON decodes stored points leniently: invalid fields are left zero
+// so a single bad point never breaks a page.
+func (c *CollectionData) UnmarshalJSON(b []byte) error {
+	if t := bytes.TrimSpace(b); len(t) == 0 || t[0] != '{' {
+		return fmt.Errorf("collection: expected object")
+	}
+	d := &collectionDecoder{verr: &ValidationError{}}
+	*c = d.collection("Collection", b)
+	return nil
+}
+
+func (c CollectionData) Empty() bool {
+	return len(c.Disk) == 0 && c.Memory == nil && len(c.Load) == 0 && c.Network == nil &&
+		len(c.Thermal) == 0 && c.Battery == nil && c.IO == nil && len(c.Ping) == 0
+}
+
+// Has reports whether the collection contains the named section.
+func (c CollectionData) Has(section string) bool {
+	switch section {
+	case "Disk":
+		return len(c.Disk) > 0
+	case "Memory":
+		return c.Memory != nil
+	case "Load":
+		return len(c.Load) > 0
+	case "Network":
+		return c.Network != nil
+	case "Thermal":
+		return len(c.Thermal) > 0
+	case "Battery":
+		return c.Battery != nil
+	case "IO":
+		return c.IO != nil
+	case "Ping":
+		return len(c.Ping) > 0
+	}
+	return false
+}
+
+// Lookup returns a value by its path in the JSON schema,
+// e.g. ("Memory", "Mem", "percent"), ("Disk", "/", "used") or ("Load", "user").
+func (c CollectionData) Lookup(path ...string) (float64, bool) {
+	if len(path) < 2 {
+		return 0, false
+	}
+	var n *Number
+	switch section, key := path[0], path[1]; {
+	case section == "Load" && len(path) == 2:
+		if v, ok := c.Load[key]; ok {
+			n = &v
+		}
+	case section == "Thermal" && len(path) == 2:
+		if v, ok := c.Thermal[key]; ok {
+			n = &v
+		}
+	case section == "Battery" && len(path) == 2 && c.Battery != nil:
+		switch key {
+		case "percent":
+			n = &c.Battery.Percent
+		case "secsleft":
+			n = c.Battery.Secsleft
+		}
+	case len(path) != 3:
+	case section == "Memory" && c.Memory != nil:
+		switch key {
+		case "Mem":
+			n = c.Memory.Mem.field(path[2])
+		case "Swap":
+			n = c.Memory.Swap.field(path[2])
+		}
+	case section == "Disk":
+		if s, ok := c.Disk[key]; ok {
+			n = (*MemoryStat)(&s).field(path[2])
+		}
+	case section == "Network" && c.Network != nil:
+		switch key {
+		case "RX":
+			n = c.Network.RX.field(path[2])
+		case "TX":
+			n = c.Network.TX.field(path[2])
+		}
+	case section == "IO" && c.IO != nil:
+		switch key {
+		case "read":
+			n = c.IO.Read.field(path[2])
+		case "write":
+			n = c.IO.Write.field(path[2])
+		}
+	case section == "Ping":
+		if t, ok := c.Ping[key]; ok {
+			switch path[2] {
+			case "latency":
+				n = t.Latency
+			case "loss":
+				n = &t.Loss
+			}
+		}
+	}
+	if n == nil {
+		return 0, false
+	}
+	return n.Float(), true
+}
+
+func (s *MemoryStat) field(name string) *Number {
+	switch name {
+	case "total":
+		return &s.Total
+	case "used":
+		return &s.Used
+	case "free":
+		return &s.Free
+	case "percent":
+		return &s.Percent
+	}
+	return nil
+}
+
+func (s *NetworkStat) field(name string) *Number {
+	switch name {
+	case "bytes":
+		return &s.Bytes
+	case "packets":
+		return &s.Packets
+	}
+	return nil
+}
+
+func (s *NetworkStat) add(o NetworkStat) {
+	s.Bytes += o.Bytes
+	s.Packets += o.Packets
+}
+
+func (s *IOStat) add(o IOStat) {
+	s.Count += o.Count
+	s.Bytes += o.Bytes
+	s.Time += o.Time
+}
+
+func (s *IOStat) field(name string) *Number {
+	switch name {
+	case "count":
+		return &s.Count
+	case "bytes":
+		return &s.Bytes
+	case "time":
+		return &s.Time
+	}
+	return nil
+}
+
+// collectionDecoder walks a payload section by section and records every
+// problem with its path instead of stopping at the first one.
+type collectionDecoder struct {
+	verr *ValidationError
+}
+
+func present(raw json.RawMessage) bool {
+	return len(raw) > 0 && string(raw) != "null"
+}
+
+func (d *collectionDecoder) object(path string, raw json.RawMessage) (map[string]json.RawMessage, bool) {
+	if !present(raw) {
+		d.verr.add("%s: required", path)
+		return nil, false
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &m); err != nil {
+		var v interface{}
+		_ = json.Unmarshal(raw, &v)
+		d.verr.add("%s: expected object, got %s", path, jsonKind(v))
+		return nil, false
+	}
+	return m, true
+}
+
+func (d *collectionDecoder) number(path string, raw json.RawMessage) Number {
+	if !present(raw) {
+		d.verr.add("%s: required", path)
+		return 0
+	}
+	f, err := parseNumber(raw)
+	if err != nil {
+		d.verr.add("%s: %v", path, err)
+	}
+	return Number(f)
+}
+
+func (d *collectionDecoder) optionalNumber(path string, raw json.RawMessage) *Number {
+	if !present(raw) {
+		return nil
+	}
+	n := d.number(path, raw)
+	return &n
+}
+
+func (d *collectionDecoder) optionalBool(path string, raw json.RawMessage) *bool {
+	if !present(raw) {
+		return nil
+	}
+	var b bool
+	if err := json.Unmarshal(raw, &b); err != nil {
+		d.verr.add("%s: expected boolean", path)
+		return nil
+	}
+	return &b
+}
+
+// numbers decodes a flat object of numbers such as Load or Thermal.
+func (d *collectionDecoder) numbers(path string, raw json.RawMessage) map[string]Number {
+	m, ok := d.object(path, raw)
+	if !ok {
+		return nil
+	}
+	result := make(map[string]Number, len(m))
+	for k, v := range m {
+		result[k] = d.number(path+"."+k, v)
+	}
+	return result
+}
+
+// memoryStat decodes total and used (required) and free and percent (optional,
+// percent is derived from used/total when missing).
+func (d *collectionDecoder) memoryStat(path string, raw json.RawMessage) MemoryStat {
+	var s MemoryStat
+	m, ok := d.object(path, raw)
+	if !ok {
+		return s
+	}
+	s.Total = d.number(path+".total", m["total"])
+	s.Used = d.number(path+".used", m["used"])
+	if present(m["free"]) {
+		s.Free = d.number(path+".free", m["free"])
+	} else {
+		s.Free = s.Total - s.Used
+	}
+	if present(m["percent"]) {
+		s.Percent = d.number(path+".percent", m["percent"])
+	} else if s.Total > 0 {
+		s.Percent = s.Used / s.Total * 100
+	}
+	return s
+}
+
+func (d *collectionDecoder) networkStat(path string, raw json.RawMessage) NetworkStat {
+	var s NetworkStat
+	if m, ok := d.object(path, raw); ok {
+		s.Bytes = d.number(path+".bytes", m["bytes"])
+		s.Packets = d.number(path+".packets", m["packets"])
+	}
+	return s
+}
+
+func (d *collectionDecoder) ioStat(path string, raw json.RawMessage) IOStat {
+	var s IOStat
+	if m, ok := d.object(path, raw); ok {
+		s.Count = d.number(path+".count", m["count"])
+		s.Bytes = d.number(path+".bytes", m["bytes"])
+		s.Time = d.number(path+".time", m["time"])
+	}
+	return s
+}
+
+// network decodes the RX/TX totals and the optional Interfaces breakdown.
+// The totals are summed from the interfaces when missing.
+func (d *collectionDecoder) network(path string, raw json.RawMessage) *Network {
+	s, ok := d.object(path, raw)
+	if !ok {
+		return nil
+	}
+	n := &Network{}
+	if present(s["Interfaces"]) {
+		if m, ok := d.object(path+".Interfaces", s["Interfaces"]); ok {
+			n.Interfaces = make(map[string]NetworkInterface, len(m))
+			for name, v := range m {
+				p := path + ".Interfaces." + name
+				if i, ok := d.object(p, v); ok {
+					n.Interfaces[name] = NetworkInterface{
+						RX: d.networkStat(p+".RX", i["RX"]),
+						TX: d.networkStat(p+".TX", i["TX"]),
+					}
+				}
+			}
+		}
+	}
+	if len(n.Interfaces) > 0 && !present(s["RX"]) && !present(s["TX"]) {
+		for _, i := range n.Interfaces {
+			n.RX.add(i.RX)
+			n.TX.add(i.TX)
+		}
+		return n
+	}
+	n.RX = d.networkStat(path+".RX", s["RX"])
+	n.TX = d.networkStat(path+".TX", s["TX"])
+	return n
+}
+
+// io decodes the read/write totals and the optional Devices breakdown.
+// The totals are summed from the devices when missing.
+func (d *collectionDecoder) io(path string, raw json.RawMessage) *IO {
+	s, ok := d.object(path, raw)
+	if !ok {
+		return nil
+	}
+	io := &IO{}
+	if present(s["Devices"]) {
+		if m, ok := d.object(path+".Devices", s["Devices"]); ok {
+			io.Devices = make(map[string]IODevice, len(m))
+			for name, v := range m {
+				p := path + ".Devices." + name
+				if dev, ok := d.object(p, v); ok {
+					io.Devices[name] = IODevice{
+						Read:  d.ioStat(p+".read", dev["read"]),
+						Write: d.ioStat(p+".write", dev["write"]),
+					}
+				}
+			}
+		}
+	}
+	if len(io.Devices) > 0 && !present(s["read"]) && !present(s["write"]) {
+		for _, dev := range io.Devices {
+			io.Read.add(dev.Read)
+			io.Write.add(dev.Write)
+		}
+		return io
+	}
+	io.Read = d.ioStat(path+".read", s["read"])
+	io.Write = d.ioStat(path+".write", s["write"])
+	return io
+}
+
+func (d *collectionDecoder) battery(path string, raw json.RawMessage) *Battery {
+	m, ok := d.object(path, raw)
+	if !ok {
+		return nil
+	}
+	b := &Battery{
+		Percent:      d.number(path+".percent", m["percent"]),
+		PowerPlugged: d.optionalBool(path+".power_plugged", m["power_plugged"]),
+		Charging:     d.optionalBool(path+".charging", m["charging"]),
+		Secsleft:     d.optionalNumber(path+".secsleft", m["secsleft"]),
+	}
+	if b.PowerPlugged == nil {
+		b.PowerPlugged = d.optionalBool(path+".plugged", m["plugged"])
+	}
+	if present(m["status"]) {
+		if err := json.Unmarshal(m["status"], &b.Status); err != nil {
+			d.verr.add("%s.status: expected string", path)
+		}
+	}
+	return b
+}
+
+// pingTarget accepts {"latency": ms, "loss": percent} or a plain latency.
+// Latency is dropped when every probe was lost.
+func (d *collectionDecoder) pingTarget(path string, raw json.RawMessage) PingTarget {
+	var t PingTarget
+	if f, err := parseNumber(raw); err == nil {
+		n := Number(f)
+		t.Latency = &n
+		return t
+	}
+	m, ok := d.object(path, raw)
+	if !ok {
+		return t
+	}
+	t.Latency = d.optionalNumber(path+".latency", m["latency"])
+	if present(m["loss"]) {
+		t.Loss = d.number(path+".loss", m["loss"])
+	}
+	if t.Loss >= 100 {
+		t.Latency = nil
+	}
+	return t
+}
+
+func (d *collectionDecoder) collection(path string, raw json.RawMessage) CollectionData {
+	c := CollectionData{Version: 1}
+	m, ok := d.object(path, raw)
+	if !ok {
+		return c
+	}
+
+	if present(m["Version"]) {
+		v := d.number("Version", m["Version"])
+		if v != Number(int(v)) || v < 1 || int(v) > CollectionVersion {
+			d.verr.add("Version: unsupported version %s, up to %d is supported", v, CollectionVersion)
+		} else {
+			c.Version = int(v)
+		}
+	}
+
+	for section, raw := range m {
+		if !present(raw) {
+			continue
+		}
+		switch section {
+		case "Memory":
+			if s, ok := d.object(section, raw); ok {
+				c.Memory = &Memory{Mem: d.memoryStat(section+".Mem", s["Mem"])}
+				// Hosts and containers without swap may leave it out.
+				if present(s["Swap"]) {
+					c.Memory.Swap = d.memoryStat(section+".Swap", s["Swap"])
+				}
+			}
+		case "Disk":
+			if s, ok := d.object(section, raw); ok {
+				c.Disk = make(map[string]DiskStat, len(s))
+				for mountpoint, stat := range s {
+					c.Disk[mountpoint] = DiskStat(d.memoryStat(section+"."+mountpoint, stat))
+				}
+			}
+		case "Network":
+			c.Network = d.network(section, raw)
+		case "IO":
+			c.IO = d.io(section, raw)
+		case "Load":
+			c.Load = d.numbers(section, raw)
+		case "Thermal":
+			c.Thermal = d.numbers(section, raw)
+		case "Battery":
+			c.Battery = d.battery(section, raw)
+		case "Ping":
+			if s, ok := d.object(section, raw); ok {
+				c.Ping = make(map[string]PingTarget, len(s))
+				for target, v := range s {
+					c.Ping[target] = d.pingTarget(section+"."+target, v)
+				}
+			}
+		}
+	}
+	return c
+}
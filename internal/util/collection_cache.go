@@ -0,0 +1,136 @@
+package util
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/elliotchance/orderedmap/v3"
+)
+
+// CollectionLRU is a least recently used cache of node collections bounded by
+// their total number of points. ccache is not used here as it miscounts its
+// size while it holds a single entry, which is the usual one node setup.
+//
+// Collections are replaced and never modified in place, so callers can keep
+// reading one while it is refreshed.
+type CollectionLRU struct {
+	mu      sync.Mutex
+	max     int64
+	points  int64
+	order   *list.List // most recently used first
+	entries map[string]*list.Element
+
+	hits, misses, evictions int64
+}
+
+type collectionEntry struct {
+	key        string
+	collection *orderedmap.OrderedMap[int64, CollectionData]
+	expires    time.Time
+}
+
+type CacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Entries   int
+	Points    int64
+}
+
+func NewCollectionLRU(maxPoints int64) *CollectionLRU {
+	return &CollectionLRU{
+		max:     maxPoints,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// Get returns the collection cached under key, counting a hit when it has not
+// expired yet and a miss otherwise. Expired collections are still returned so
+// they can be refreshed incrementally.
+func (c *CollectionLRU) Get(key string) (collection *orderedmap.OrderedMap[int64, CollectionData], fresh bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	collection, fresh = c.get(key)
+	if el, ok := c.entries[key]; ok {
+		c.order.MoveToFront(el)
+	}
+	if fresh {
+		c.hits++
+	} else {
+		c.misses++
+	}
+	return collection, fresh
+}
+
+// Peek is Get without counting it or marking the collection as used.
+func (c *CollectionLRU) Peek(key string) *orderedmap.OrderedMap[int64, CollectionData] {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	collection, _ := c.get(key)
+	return collection
+}
+
+func (c *CollectionLRU) get(key string) (*orderedmap.OrderedMap[int64, CollectionData], bool) {
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*collectionEntry)
+	return e.collection, time.Now().Before(e.expires)
+}
+
+// Set caches collection for ttl, then evicts the least recently used
+// collections until the cache is back within its size. Replacing a
+// collection does not count as using it. A collection larger than the whole
+// cache is not cached, only its stale copy is dropped.
+func (c *CollectionLRU) Set(key string, collection *orderedmap.OrderedMap[int64, CollectionData], ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if int64(collection.Len()) > c.max {
+		c.remove(key)
+		return
+	}
+	e := &collectionEntry{key: key, collection: collection, expires: time.Now().Add(ttl)}
+	if el, ok := c.entries[key]; ok {
+		c.points -= int64(el.Value.(*collectionEntry).collection.Len())
+		el.Value = e
+	} else {
+		c.entries[key] = c.order.PushFront(e)
+	}
+	c.points += int64(collection.Len())
+
+	for c.points > c.max && c.order.Len() > 0 {
+		c.remove(c.order.Back().Value.(*collectionEntry).key)
+		c.evictions++
+	}
+}
+
+func (c *CollectionLRU) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(key)
+}
+
+func (c *CollectionLRU) remove(key string) {
+	el, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	c.order.Remove(el)
+	delete(c.entries, key)
+	c.points -= int64(el.Value.(*collectionEntry).collection.Len())
+}
+
+func (c *CollectionLRU) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Entries:   len(c.entries),
+		Points:    c.points,
+	}
+}
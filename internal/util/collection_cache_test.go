@@ -0,0 +1,152 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/elliotchance/orderedmap/v3"
+)
+
+func TestCollectionLRU(t *testing.T) {
+	c := NewCollectionLRU(4)
+	collection := func(points int) *orderedmap.OrderedMap[int64, CollectionData] {
+		m := orderedmap.NewOrderedMap[int64, CollectionData]()
+		for i := 0; i < points; i++ {
+			m.Set(int64(i), CollectionData{})
+		}
+		return m
+	}
+
+	c.Set("a", collection(2), time.Minute)
+	c.Set("b", collection(2), -time.Second)
+	if _, fresh := c.Get("a"); !fresh {
+		t.Error("Get(a) is not fresh")
+	}
+	if m, fresh := c.Get("b"); m == nil || fresh {
+		t.Errorf("Get(b) = %v, %v; want the expired collection", m, fresh)
+	}
+	if m, fresh := c.Get("c"); m != nil || fresh {
+		t.Errorf("Get(c) = %v, %v; want nothing", m, fresh)
+	}
+
+	// a was used before b, replacing a does not count as using it again.
+	c.Get("a")
+	c.Get("b")
+	c.Set("a", collection(1), time.Minute)
+	c.Set("c", collection(2), time.Minute)
+	if c.Peek("a") != nil {
+		t.Error("least recently used a was not evicted")
+	}
+	if c.Peek("b") == nil || c.Peek("c") == nil {
+		t.Error("b or c was evicted")
+	}
+
+	c.Delete("b")
+	// A collection larger than the whole cache is not cached and evicts
+	// nothing else, but its stale copy goes away.
+	c.Set("d", collection(1), time.Minute)
+	c.Set("d", collection(5), time.Minute)
+	if c.Peek("d") != nil {
+		t.Error("a collection larger than the cache was cached")
+	}
+	if c.Peek("c") == nil {
+		t.Error("a collection larger than the cache evicted c")
+	}
+
+	want := CacheStats{Hits: 2, Misses: 3, Evictions: 1, Entries: 1, Points: 2}
+	if got := c.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func loadPoint(t int64, user int) Point {
+	return Point{Time: t, Data: fmt.Sprintf(`{"Load":{"user":%d}}`, user)}
+}
+
+func TestCollectionCacheIncremental(t *testing.T) {
+	s := setupTestStore(t)
+	SetupCollectionCache()
+	ctx := context.Background()
+	now := time.Now().Unix()
+	s.AppendPoints(ctx, SeriesCollection, "n1", loadPoint(now-8*86400, 0), loadPoint(now-120, 1), loadPoint(now-60, 2))
+
+	before := CollectionCache.Stats()
+	c, err := GetCollection("n1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The point older than DATA_RETENTION_DAYS is not cached.
+	if c.Len() != 2 || c.Front().Key != now-120 {
+		t.Fatalf("GetCollection() = %d points from %d, want 2 from %d", c.Len(), c.Front().Key, now-120)
+	}
+
+	s.AppendPoints(ctx, SeriesCollection, "n1", loadPoint(now, 3))
+	if c, _ := GetCollection("n1", false); c.Len() != 2 {
+		t.Errorf("cached GetCollection() = %d points, want 2", c.Len())
+	}
+
+	// Drop the cached points from the store, a refresh must not read them again.
+	s.Retain(ctx, SeriesCollection, "n1", now-1)
+	c, err = GetCollection("n1", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != 3 || c.Back().Key != now || c.Back().Value.Load["user"].Float() != 3 {
+		t.Errorf("refreshed GetCollection() = %d points up to %d, want 3 up to %d", c.Len(), c.Back().Key, now)
+	}
+
+	stats := CollectionCache.Stats()
+	if hits, misses := stats.Hits-before.Hits, stats.Misses-before.Misses; hits != 1 || misses != 1 {
+		t.Errorf("hits, misses = %d, %d; want 1, 1", hits, misses)
+	}
+	if stats.Entries != 1 || stats.Points != 3 {
+		t.Errorf("entries, points = %d, %d; want 1, 3", stats.Entries, stats.Points)
+	}
+
+	// After a restart the disk cache is refreshed instead of the whole series.
+	SetupCollectionCache()
+	if c, _ := GetCollection("n1", false); c == nil || c.Len() != 3 {
+		t.Errorf("GetCollection() after restart = %v, want the 3 points on disk", c)
+	}
+}
+
+func TestCollectionCacheUnknownNode(t *testing.T) {
+	setupTestStore(t)
+	SetupCollectionCache()
+
+	if _, err := GetCollection("missing", false); err == nil {
+		t.Error("GetCollection() of an unknown node returned no error")
+	}
+	if n := CollectionCache.Stats().Entries; n != 0 {
+		t.Errorf("unknown node cached, %d entries", n)
+	}
+}
+
+func TestCollectionCacheSize(t *testing.T) {
+	s := setupTestStore(t)
+	setTestConfig(t, func(c *Config) { c.Cache.CollectionSize = 3 })
+	SetupCollectionCache()
+	ctx := context.Background()
+	now := time.Now().Unix()
+
+	before := CollectionCache.Stats()
+	for _, uuid := range []string{"n1", "n2"} {
+		s.AppendPoints(ctx, SeriesCollection, uuid, loadPoint(now-60, 1), loadPoint(now, 2))
+		if _, err := GetCollection(uuid, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := CollectionCache.Stats()
+	if stats.Entries != 1 || stats.Points != 2 {
+		t.Errorf("entries, points = %d, %d; want 1, 2", stats.Entries, stats.Points)
+	}
+	if n := stats.Evictions - before.Evictions; n != 1 {
+		t.Errorf("evictions = %d, want 1", n)
+	}
+	if CollectionCache.Peek("system_monitor:collection:n2") == nil {
+		t.Error("the most recent node was evicted")
+	}
+}
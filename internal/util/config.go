@@ -0,0 +1,396 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the whole configuration of the server.
+//
+// Every setting is read from, by increasing priority: the `default` tag, the
+// YAML or TOML file named by CONFIG_FILE, then the environment variable of
+// its `env` tag (which includes .env, see LoadEnv). Settings tagged
+// `reload:"true"` are applied by ReloadConfig, the others need a restart.
+type Config struct {
+	Server ServerConfig `yaml:"server" toml:"server"`
+	Store  StoreConfig  `yaml:"store" toml:"store"`
+	Redis  RedisConfig  `yaml:"redis" toml:"redis"`
+	Cache  CacheConfig  `yaml:"cache" toml:"cache"`
+	Data   DataConfig   `yaml:"data" toml:"data"`
+	Admin  AdminConfig  `yaml:"admin" toml:"admin"`
+	Alert  AlertConfig  `yaml:"alert" toml:"alert"`
+}
+
+type ServerConfig struct {
+	ListenAddress string `yaml:"listen_address" toml:"listen_address" env:"LISTEN_ADDRESS" default:"127.0.0.1"`
+	ListenPort    int    `yaml:"listen_port" toml:"listen_port" env:"LISTEN_PORT" default:"8888"`
+	Debug         bool   `yaml:"debug" toml:"debug" env:"IS_DEBUG"`
+	// TrustedProxies is a comma separated list of addresses or CIDRs.
+	TrustedProxies string `yaml:"trusted_proxies" toml:"trusted_proxies" env:"TRUSTED_PROXIES"`
+	BaseURL        string `yaml:"base_url" toml:"base_url" env:"BASE_URL" reload:"true"`
+	MetricsToken   string `yaml:"metrics_token" toml:"metrics_token" env:"METRICS_TOKEN" reload:"true"`
+	APIMaxPoints   int    `yaml:"api_max_points" toml:"api_max_points" env:"API_MAX_POINTS" reload:"true"`
+	// ReportMaxSkew is in seconds.
+	ReportMaxSkew int `yaml:"report_max_skew" toml:"report_max_skew" env:"REPORT_MAX_SKEW" default:"300" reload:"true"`
+	// ShutdownTimeout bounds, in seconds, how long in-flight requests and
+	// cron tasks are waited for on SIGINT or SIGTERM.
+	ShutdownTimeout int `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" default:"15" reload:"true"`
+}
+
+type StoreConfig struct {
+	Backend  string `yaml:"backend" toml:"backend" env:"STORE" default:"redis"`
+	BoltPath string `yaml:"bolt_path" toml:"bolt_path" env:"BOLT_PATH" default:"./data/monitor.db"`
+}
+
+type RedisConfig struct {
+	Host        string `yaml:"host" toml:"host" env:"REDIS_HOST" default:"127.0.0.1"`
+	Port        string `yaml:"port" toml:"port" env:"REDIS_PORT" default:"6379"`
+	Password    string `yaml:"password" toml:"password" env:"REDIS_PASSWORD"`
+	DB          int    `yaml:"db" toml:"db" env:"REDIS_DB"`
+	TLSEnabled  bool   `yaml:"tls_enabled" toml:"tls_enabled" env:"REDIS_TLS_ENABLED"`
+	TLSInsecure bool   `yaml:"tls_insecure" toml:"tls_insecure" env:"REDIS_TLS_INSECURE"`
+	TLSCertFile string `yaml:"tls_cert_file" toml:"tls_cert_file" env:"REDIS_TLS_CERT_FILE"`
+	TLSKeyFile  string `yaml:"tls_key_file" toml:"tls_key_file" env:"REDIS_TLS_KEY_FILE"`
+	TLSCAFile   string `yaml:"tls_ca_file" toml:"tls_ca_file" env:"REDIS_TLS_CA_FILE"`
+}
+
+type CacheConfig struct {
+	// LocalTime is how long, in seconds, local caches are kept.
+	LocalTime      int    `yaml:"local_time" toml:"local_time" env:"LOCAL_CACHE_TIME" default:"300" reload:"true"`
+	CollectionSize int    `yaml:"collection_size" toml:"collection_size" env:"COLLECTION_CACHE_SIZE" default:"100000"`
+	DiskPath       string `yaml:"disk_path" toml:"disk_path" env:"DISK_CACHE_PATH" default:"./cache/"`
+	DiskTTL        int    `yaml:"disk_ttl" toml:"disk_ttl" env:"DISK_CACHE_TTL" default:"86400" reload:"true"`
+	DiskSizeMB     int    `yaml:"disk_size_mb" toml:"disk_size_mb" env:"DISK_CACHE_SIZE_MB" default:"256" reload:"true"`
+}
+
+type DataConfig struct {
+	// OfflineThreshold and CronJobInterval are in seconds.
+	OfflineThreshold      int `yaml:"offline_threshold" toml:"offline_threshold" env:"OFFLINE_THRESHOLD" default:"600" reload:"true"`
+	CronJobInterval       int `yaml:"cron_job_interval" toml:"cron_job_interval" env:"CRON_JOB_INTERVAL" default:"60" reload:"true"`
+	RetentionDays         int `yaml:"retention_days" toml:"retention_days" env:"DATA_RETENTION_DAYS" default:"7" reload:"true"`
+	Rollup5mRetentionDays int `yaml:"rollup_5m_retention_days" toml:"rollup_5m_retention_days" env:"ROLLUP_5M_RETENTION_DAYS" default:"30" reload:"true"`
+	Rollup1hRetentionDays int `yaml:"rollup_1h_retention_days" toml:"rollup_1h_retention_days" env:"ROLLUP_1H_RETENTION_DAYS" default:"365" reload:"true"`
+	// CronConcurrency bounds the cron tasks running at once, each of them
+	// limited to CronTaskTimeout seconds. The tasks of every node start at a
+	// random time within the first CronJitter percent of the interval.
+	CronConcurrency int `yaml:"cron_concurrency" toml:"cron_concurrency" env:"CRON_CONCURRENCY" default:"8" reload:"true"`
+	CronTaskTimeout int `yaml:"cron_task_timeout" toml:"cron_task_timeout" env:"CRON_TASK_TIMEOUT" default:"30" reload:"true"`
+	CronJitter      int `yaml:"cron_jitter" toml:"cron_jitter" env:"CRON_JITTER" default:"50" reload:"true"`
+}
+
+type AdminConfig struct {
+	Username      string `yaml:"username" toml:"username" env:"ADMIN_USERNAME" default:"admin" reload:"true"`
+	PasswordHash  string `yaml:"password_hash" toml:"password_hash" env:"ADMIN_PASSWORD_HASH" reload:"true"`
+	PasswordFile  string `yaml:"password_file" toml:"password_file" env:"ADMIN_PASSWORD_FILE" reload:"true"`
+	SessionSecret string `yaml:"session_secret" toml:"session_secret" env:"SESSION_SECRET"`
+	// SessionTTL and LockoutSeconds are in seconds.
+	SessionTTL       int  `yaml:"session_ttl" toml:"session_ttl" env:"ADMIN_SESSION_TTL" default:"86400" reload:"true"`
+	MaxLoginAttempts int  `yaml:"max_login_attempts" toml:"max_login_attempts" env:"ADMIN_MAX_LOGIN_ATTEMPTS" default:"5" reload:"true"`
+	LockoutSeconds   int  `yaml:"lockout_seconds" toml:"lockout_seconds" env:"ADMIN_LOCKOUT_SECONDS" default:"900" reload:"true"`
+	CookieSecure     bool `yaml:"cookie_secure" toml:"cookie_secure" env:"ADMIN_COOKIE_SECURE" reload:"true"`
+}
+
+type AlertConfig struct {
+	Rules     string `yaml:"rules" toml:"rules" env:"ALERT_RULES" reload:"true"`
+	RulesFile string `yaml:"rules_file" toml:"rules_file" env:"ALERT_RULES_FILE" reload:"true"`
+	// RetryBackoff is in seconds.
+	RetryAttempts    int    `yaml:"retry_attempts" toml:"retry_attempts" env:"ALERT_RETRY_ATTEMPTS" default:"3"`
+	RetryBackoff     int    `yaml:"retry_backoff" toml:"retry_backoff" env:"ALERT_RETRY_BACKOFF" default:"1"`
+	WebhookURL       string `yaml:"webhook_url" toml:"webhook_url" env:"ALERT_WEBHOOK_URL"`
+	WebhookTemplate  string `yaml:"webhook_template" toml:"webhook_template" env:"ALERT_WEBHOOK_TEMPLATE"`
+	TelegramToken    string `yaml:"telegram_token" toml:"telegram_token" env:"ALERT_TELEGRAM_TOKEN"`
+	TelegramChatID   string `yaml:"telegram_chat_id" toml:"telegram_chat_id" env:"ALERT_TELEGRAM_CHAT_ID"`
+	TelegramAPIURL   string `yaml:"telegram_api_url" toml:"telegram_api_url" env:"ALERT_TELEGRAM_API_URL" default:"https://api.telegram.org"`
+	TelegramTemplate string `yaml:"telegram_template" toml:"telegram_template" env:"ALERT_TELEGRAM_TEMPLATE"`
+	SMTPHost         string `yaml:"smtp_host" toml:"smtp_host" env:"ALERT_SMTP_HOST"`
+	SMTPPort         int    `yaml:"smtp_port" toml:"smtp_port" env:"ALERT_SMTP_PORT" default:"587"`
+	SMTPUsername     string `yaml:"smtp_username" toml:"smtp_username" env:"ALERT_SMTP_USERNAME"`
+	SMTPPassword     string `yaml:"smtp_password" toml:"smtp_password" env:"ALERT_SMTP_PASSWORD"`
+	SMTPFrom         string `yaml:"smtp_from" toml:"smtp_from" env:"ALERT_SMTP_FROM"`
+	// SMTPTo is a comma separated list of recipients.
+	SMTPTo       string `yaml:"smtp_to" toml:"smtp_to" env:"ALERT_SMTP_TO"`
+	SMTPSubject  string `yaml:"smtp_subject" toml:"smtp_subject" env:"ALERT_SMTP_SUBJECT" default:"[{{ .State | upper }}] {{ .Rule }} on {{ .Name }}"`
+	SMTPTemplate string `yaml:"smtp_template" toml:"smtp_template" env:"ALERT_SMTP_TEMPLATE"`
+}
+
+// ConfigError lists every invalid setting.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
+
+func (e *ConfigError) add(format string, a ...any) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, a...))
+}
+
+var config atomic.Pointer[Config]
+
+// Conf returns the current configuration. Until SetupConfig is called it is
+// read from the defaults and the environment on first use.
+func Conf() *Config {
+	if c := config.Load(); c != nil {
+		return c
+	}
+	c, err := LoadConfig()
+	if err != nil {
+		fmt.Println("Error loading configuration:", err)
+	}
+	config.CompareAndSwap(nil, c)
+	return config.Load()
+}
+
+// SetConfig replaces the current configuration.
+func SetConfig(c *Config) {
+	config.Store(c)
+}
+
+// SetupConfig loads and validates the configuration, then makes it current.
+func SetupConfig() error {
+	c, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	SetConfig(c)
+	return nil
+}
+
+// ReloadConfig reads .env and the configuration again and applies the
+// settings that can change at runtime. The current configuration is kept
+// when the new one is invalid. It returns the env names of the changed
+// settings that need a restart to be applied.
+func ReloadConfig() ([]string, error) {
+	if err := LoadEnv(); err != nil {
+		return nil, err
+	}
+	loaded, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	current := *Conf()
+	var restart []string
+	walkConfig(&current, func(cur reflect.Value, field reflect.StructField, path []int) {
+		next := reflect.ValueOf(loaded).Elem().FieldByIndex(path)
+		if reflect.DeepEqual(cur.Interface(), next.Interface()) {
+			return
+		}
+		if field.Tag.Get("reload") == "true" {
+			cur.Set(next)
+		} else {
+			restart = append(restart, field.Tag.Get("env"))
+		}
+	})
+	SetConfig(&current)
+	return restart, nil
+}
+
+// LoadConfig reads the configuration without making it current.
+// On error the returned configuration holds every valid setting.
+func LoadConfig() (*Config, error) {
+	c := &Config{}
+	cerr := &ConfigError{}
+
+	walkConfig(c, func(v reflect.Value, field reflect.StructField, _ []int) {
+		if d, ok := field.Tag.Lookup("default"); ok {
+			if err := setConfigValue(v, d); err != nil {
+				panic(fmt.Sprintf("invalid default of %s: %v", field.Name, err))
+			}
+		}
+	})
+
+	if path := GetEnv("CONFIG_FILE", ""); path != "" {
+		if err := decodeConfigFile(path, c); err != nil {
+			cerr.add("CONFIG_FILE %s: %v", path, err)
+		}
+	}
+
+	walkConfig(c, func(v reflect.Value, field reflect.StructField, _ []int) {
+		name := field.Tag.Get("env")
+		s, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		if err := setConfigValue(v, s); err != nil {
+			cerr.add("%s: %v", name, err)
+		}
+	})
+
+	c.validate(cerr)
+	if len(cerr.Problems) > 0 {
+		return c, cerr
+	}
+	return c, nil
+}
+
+// decodeConfigFile decodes a .yaml, .yml or .toml file into c. Unknown keys
+// are errors so that typos do not go unnoticed.
+func decodeConfigFile(path string, c *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		// An empty file is no error.
+		if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		return nil
+	case ".toml":
+		dec := toml.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err := dec.Decode(c)
+		var strict *toml.StrictMissingError
+		if errors.As(err, &strict) {
+			keys := make([]string, 0, len(strict.Errors))
+			for _, e := range strict.Errors {
+				keys = append(keys, strings.Join(e.Key(), "."))
+			}
+			return fmt.Errorf("unknown keys %s", strings.Join(keys, ", "))
+		}
+		return err
+	default:
+		return fmt.Errorf("unsupported extension %q, use .yaml, .yml or .toml", ext)
+	}
+}
+
+// walkConfig calls fn with every setting of c, path being its index for
+// reflect.Value.FieldByIndex.
+func walkConfig(c *Config, fn func(v reflect.Value, field reflect.StructField, path []int)) {
+	var walk func(v reflect.Value, path []int)
+	walk = func(v reflect.Value, path []int) {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			p := append(append([]int{}, path...), i)
+			if field.Type.Kind() == reflect.Struct {
+				walk(v.Field(i), p)
+				continue
+			}
+			fn(v.Field(i), field, p)
+		}
+	}
+	walk(reflect.ValueOf(c).Elem(), nil)
+}
+
+func setConfigValue(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int:
+		i, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", s)
+		}
+		v.SetInt(int64(i))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(s))
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", s)
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func (c *Config) validate(e *ConfigError) {
+	port := func(name string, p int) {
+		if p < 1 || p > 65535 {
+			e.add("%s: must be between 1 and 65535, got %d", name, p)
+		}
+	}
+	positive := func(name string, v int) {
+		if v <= 0 {
+			e.add("%s: must be greater than 0, got %d", name, v)
+		}
+	}
+	nonNegative := func(name string, v int) {
+		if v < 0 {
+			e.add("%s: must not be negative, got %d", name, v)
+		}
+	}
+
+	port("LISTEN_PORT", c.Server.ListenPort)
+	nonNegative("API_MAX_POINTS", c.Server.APIMaxPoints)
+	positive("REPORT_MAX_SKEW", c.Server.ReportMaxSkew)
+	positive("SHUTDOWN_TIMEOUT", c.Server.ShutdownTimeout)
+
+	switch c.Store.Backend {
+	case "redis":
+		if p, err := strconv.Atoi(c.Redis.Port); err != nil {
+			e.add("REDIS_PORT: %q is not a port number", c.Redis.Port)
+		} else {
+			port("REDIS_PORT", p)
+		}
+		nonNegative("REDIS_DB", c.Redis.DB)
+		if (c.Redis.TLSCertFile == "") != (c.Redis.TLSKeyFile == "") {
+			e.add("REDIS_TLS_CERT_FILE and REDIS_TLS_KEY_FILE must be set together")
+		}
+	case "bolt":
+		if c.Store.BoltPath == "" {
+			e.add("BOLT_PATH: required with STORE=bolt")
+		}
+	case "memory":
+	default:
+		e.add("STORE: must be redis, bolt or memory, got %q", c.Store.Backend)
+	}
+
+	positive("LOCAL_CACHE_TIME", c.Cache.LocalTime)
+	positive("COLLECTION_CACHE_SIZE", c.Cache.CollectionSize)
+	if c.Cache.DiskPath == "" {
+		e.add("DISK_CACHE_PATH: required")
+	}
+	nonNegative("DISK_CACHE_TTL", c.Cache.DiskTTL)
+	nonNegative("DISK_CACHE_SIZE_MB", c.Cache.DiskSizeMB)
+
+	positive("OFFLINE_THRESHOLD", c.Data.OfflineThreshold)
+	positive("CRON_JOB_INTERVAL", c.Data.CronJobInterval)
+	positive("DATA_RETENTION_DAYS", c.Data.RetentionDays)
+	positive("ROLLUP_5M_RETENTION_DAYS", c.Data.Rollup5mRetentionDays)
+	positive("ROLLUP_1H_RETENTION_DAYS", c.Data.Rollup1hRetentionDays)
+	// The rollup tiers are chosen from the finest by their retention.
+	if c.Data.Rollup5mRetentionDays < c.Data.RetentionDays {
+		e.add("ROLLUP_5M_RETENTION_DAYS: must not be less than DATA_RETENTION_DAYS (%d), got %d", c.Data.RetentionDays, c.Data.Rollup5mRetentionDays)
+	}
+	if c.Data.Rollup1hRetentionDays < c.Data.Rollup5mRetentionDays {
+		e.add("ROLLUP_1H_RETENTION_DAYS: must not be less than ROLLUP_5M_RETENTION_DAYS (%d), got %d", c.Data.Rollup5mRetentionDays, c.Data.Rollup1hRetentionDays)
+	}
+	positive("CRON_CONCURRENCY", c.Data.CronConcurrency)
+	positive("CRON_TASK_TIMEOUT", c.Data.CronTaskTimeout)
+	if c.Data.CronJitter < 0 || c.Data.CronJitter > 100 {
+		e.add("CRON_JITTER: must be between 0 and 100, got %d", c.Data.CronJitter)
+	}
+
+	positive("ADMIN_SESSION_TTL", c.Admin.SessionTTL)
+	positive("ADMIN_MAX_LOGIN_ATTEMPTS", c.Admin.MaxLoginAttempts)
+	nonNegative("ADMIN_LOCKOUT_SECONDS", c.Admin.LockoutSeconds)
+
+	positive("ALERT_RETRY_ATTEMPTS", c.Alert.RetryAttempts)
+	nonNegative("ALERT_RETRY_BACKOFF", c.Alert.RetryBackoff)
+	if c.Alert.TelegramToken != "" && c.Alert.TelegramChatID == "" {
+		e.add("ALERT_TELEGRAM_CHAT_ID: required with ALERT_TELEGRAM_TOKEN")
+	}
+	if c.Alert.SMTPHost != "" {
+		port("ALERT_SMTP_PORT", c.Alert.SMTPPort)
+		if c.Alert.SMTPFrom == "" || c.Alert.SMTPTo == "" {
+			e.add("ALERT_SMTP_FROM and ALERT_SMTP_TO: required with ALERT_SMTP_HOST")
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package util
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// setTestConfig applies fn to a copy of the configuration for the duration
+// of the test.
+func setTestConfig(t *testing.T, fn func(*Config)) {
+	t.Helper()
+	old := Conf()
+	c := *old
+	fn(&c)
+	SetConfig(&c)
+	t.Cleanup(func() { SetConfig(old) })
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	c, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Server.ListenPort != 8888 || c.Cache.LocalTime != 300 || c.Data.OfflineThreshold != 600 ||
+		c.Data.CronJobInterval != 60 || c.Store.Backend != "redis" || c.Data.Rollup1hRetentionDays != 365 {
+		t.Errorf("LoadConfig() defaults = %+v", c)
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": "server:\n  listen_port: 9000\ncache:\n  local_time: 30\nalert:\n  rules: '[]'\n",
+		"config.toml": "[server]\nlisten_port = 9000\n[cache]\nlocal_time = 30\n[alert]\nrules = '[]'\n",
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("CONFIG_FILE", writeConfigFile(t, name, content))
+			t.Setenv("LOCAL_CACHE_TIME", "45")
+
+			c, err := LoadConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Server.ListenPort != 9000 {
+				t.Errorf("ListenPort = %d, want 9000 from the file", c.Server.ListenPort)
+			}
+			if c.Cache.LocalTime != 45 {
+				t.Errorf("LocalTime = %d, want 45 from the environment", c.Cache.LocalTime)
+			}
+			if c.Alert.Rules != "[]" || c.Data.OfflineThreshold != 600 {
+				t.Errorf("LoadConfig() = %+v", c)
+			}
+		})
+	}
+}
+
+func TestLoadConfigEmptyFile(t *testing.T) {
+	t.Setenv("CONFIG_FILE", writeConfigFile(t, "config.yml", ""))
+	if _, err := LoadConfig(); err != nil {
+		t.Errorf("LoadConfig() with an empty file = %v", err)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := map[string]struct {
+		file string
+		env  map[string]string
+		want []string
+	}{
+		"unknown key":   {file: "server:\n  listen_prot: 9000\n", want: []string{"listen_prot"}},
+		"unknown table": {file: "[sever]\nlisten_port = 9000\n", want: []string{"sever"}},
+		"bad extension": {file: "{}", want: []string{"unsupported extension"}},
+		"not a number": {
+			env:  map[string]string{"LISTEN_PORT": "http", "IS_DEBUG": "maybe"},
+			want: []string{`LISTEN_PORT: "http" is not an integer`, `IS_DEBUG: "maybe" is not a boolean`},
+		},
+		"out of range": {
+			env:  map[string]string{"LISTEN_PORT": "70000", "CRON_JOB_INTERVAL": "0", "DISK_CACHE_TTL": "-1"},
+			want: []string{"LISTEN_PORT: must be between 1 and 65535", "CRON_JOB_INTERVAL: must be greater than 0", "DISK_CACHE_TTL: must not be negative"},
+		},
+		"rollup retention": {
+			env: map[string]string{"DATA_RETENTION_DAYS": "60", "ROLLUP_1H_RETENTION_DAYS": "10"},
+			want: []string{
+				"ROLLUP_5M_RETENTION_DAYS: must not be less than DATA_RETENTION_DAYS (60)",
+				"ROLLUP_1H_RETENTION_DAYS: must not be less than ROLLUP_5M_RETENTION_DAYS (30)",
+			},
+		},
+		"store": {env: map[string]string{"STORE": "mongo"}, want: []string{"STORE: must be redis, bolt or memory"}},
+		"redis tls": {
+			env:  map[string]string{"REDIS_TLS_CERT_FILE": "cert.pem"},
+			want: []string{"REDIS_TLS_CERT_FILE and REDIS_TLS_KEY_FILE"},
+		},
+		"smtp": {
+			env:  map[string]string{"ALERT_SMTP_HOST": "smtp.example.com"},
+			want: []string{"ALERT_SMTP_FROM and ALERT_SMTP_TO"},
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("CONFIG_FILE", "")
+			switch {
+			case strings.HasPrefix(tt.file, "["):
+				t.Setenv("CONFIG_FILE", writeConfigFile(t, "config.toml", tt.file))
+			case strings.HasPrefix(tt.file, "{"):
+				t.Setenv("CONFIG_FILE", writeConfigFile(t, "config.json", tt.file))
+			case tt.file != "":
+				t.Setenv("CONFIG_FILE", writeConfigFile(t, "config.yaml", tt.file))
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			_, err := LoadConfig()
+			var cerr *ConfigError
+			if !errors.As(err, &cerr) {
+				t.Fatalf("LoadConfig() = %v, want a ConfigError", err)
+			}
+			if len(cerr.Problems) != len(tt.want) {
+				t.Errorf("problems = %q, want %d", cerr.Problems, len(tt.want))
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("%q does not contain %q", err.Error(), want)
+				}
+			}
+		})
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("OFFLINE_THRESHOLD", "600")
+	t.Setenv("LISTEN_PORT", "8888")
+	old := Conf()
+	t.Cleanup(func() { SetConfig(old) })
+	if err := SetupConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("OFFLINE_THRESHOLD", "120")
+	os.Setenv("LISTEN_PORT", "9000")
+	restart, err := ReloadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restart, []string{"LISTEN_PORT"}) {
+		t.Errorf("ReloadConfig() restart = %v, want [LISTEN_PORT]", restart)
+	}
+	if c := Conf(); c.Data.OfflineThreshold != 120 || c.Server.ListenPort != 8888 {
+		t.Errorf("after reload OfflineThreshold, ListenPort = %d, %d; want 120, 8888",
+			c.Data.OfflineThreshold, c.Server.ListenPort)
+	}
+
+	os.Setenv("OFFLINE_THRESHOLD", "0")
+	if _, err := ReloadConfig(); err == nil {
+		t.Error("ReloadConfig() of an invalid configuration = nil")
+	}
+	if Conf().Data.OfflineThreshold != 120 {
+		t.Error("an invalid configuration was applied")
+	}
+}
+
+func TestLoadEnvReload(t *testing.T) {
+	t.Chdir(t.TempDir())
+	t.Setenv("TEST_REAL", "env")
+	t.Cleanup(func() {
+		for _, k := range []string{"TEST_DOTENV", "TEST_REMOVED"} {
+			os.Unsetenv(k)
+			delete(dotenvKeys, k)
+		}
+	})
+
+	if err := LoadEnv(); err != nil {
+		t.Errorf("LoadEnv() without .env = %v", err)
+	}
+
+	os.WriteFile(".env", []byte("TEST_DOTENV=1\nTEST_REMOVED=1\nTEST_REAL=dotenv\n"), 0o600)
+	if err := LoadEnv(); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(".env", []byte("TEST_DOTENV=2\nTEST_REAL=dotenv\n"), 0o600)
+	if err := LoadEnv(); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := os.Getenv("TEST_DOTENV"); v != "2" {
+		t.Errorf("TEST_DOTENV = %q, want the updated 2", v)
+	}
+	if _, ok := os.LookupEnv("TEST_REMOVED"); ok {
+		t.Error("TEST_REMOVED is still set after its removal from .env")
+	}
+	if v := os.Getenv("TEST_REAL"); v != "env" {
+		t.Errorf("TEST_REAL = %q, .env must not override the environment", v)
+	}
+}
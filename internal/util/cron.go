@@ -0,0 +1,224 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"time"
+)
+
+// cronTask is one unit of work of a CronJob run.
+type cronTask struct {
+	name string
+	// uuid is empty for the tasks that are not about a single node.
+	uuid string
+	// delay is how long after the start of the run the task is queued.
+	delay time.Duration
+	run   func(ctx context.Context) error
+}
+
+// CronStats are the counters of CronJob since the start of the process.
+type CronStats struct {
+	Runs            int64
+	LastRunDuration time.Duration
+	// Tasks counts the finished tasks by name, then by result: ok, error
+	// or timeout.
+	Tasks map[string]map[string]int64
+	// TaskDuration is the time spent running the tasks of each name.
+	TaskDuration map[string]time.Duration
+}
+
+var (
+	cronMu    sync.Mutex
+	cronStats = CronStats{
+		Tasks:        map[string]map[string]int64{},
+		TaskDuration: map[string]time.Duration{},
+	}
+)
+
+// GetCronStats returns a copy of the CronJob counters.
+func GetCronStats() CronStats {
+	cronMu.Lock()
+	defer cronMu.Unlock()
+	result := cronStats
+	result.Tasks = make(map[string]map[string]int64, len(cronStats.Tasks))
+	for name, results := range cronStats.Tasks {
+		result.Tasks[name] = make(map[string]int64, len(results))
+		for r, n := range results {
+			result.Tasks[name][r] = n
+		}
+	}
+	result.TaskDuration = make(map[string]time.Duration, len(cronStats.TaskDuration))
+	for name, d := range cronStats.TaskDuration {
+		result.TaskDuration[name] = d
+	}
+	return result
+}
+
+func recordCronTask(name, result string, d time.Duration) {
+	cronMu.Lock()
+	defer cronMu.Unlock()
+	if cronStats.Tasks[name] == nil {
+		cronStats.Tasks[name] = map[string]int64{}
+	}
+	cronStats.Tasks[name][result]++
+	cronStats.TaskDuration[name] += d
+}
+
+func recordCronRun(d time.Duration) {
+	cronMu.Lock()
+	defer cronMu.Unlock()
+	cronStats.Runs++
+	cronStats.LastRunDuration = d
+}
+
+// CronJob refreshes the caches, applies retention and evaluates alerts every
+// CRON_JOB_INTERVAL seconds until ctx is done. Runs never overlap: a run
+// taking longer than the interval delays the next one. Once ctx is done the
+// running tasks have half of SHUTDOWN_TIMEOUT to finish before they are
+// cancelled. On return the tasks already started and the notifications they
+// sent are done.
+func CronJob(ctx context.Context) {
+	defer notifications.Wait()
+
+	for {
+		start := time.Now()
+		interval := time.Duration(Conf().Data.CronJobInterval) * time.Second
+		runCronTasks(ctx, cronTasks(interval), Conf().Data.CronConcurrency,
+			time.Duration(Conf().Data.CronTaskTimeout)*time.Second,
+			time.Duration(Conf().Server.ShutdownTimeout)*time.Second/2)
+		recordCronRun(time.Since(start))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval - time.Since(start)):
+		}
+	}
+}
+
+// cronTasks lists the tasks of one run. The tasks about all nodes are queued
+// first, those of each node after a random delay within the first
+// CRON_JITTER percent of interval so the store is not queried all at once.
+func cronTasks(interval time.Duration) []cronTask {
+	uuids, err := GetUUIDs(true)
+	if err != nil {
+		fmt.Println("Error getting uuids for cron job:", err)
+	}
+
+	tasks := []cronTask{
+		{name: "names", run: func(ctx context.Context) error {
+			_, err := getDisplayName(ctx, true)
+			return err
+		}},
+		{name: "alerts", run: EvaluateAlerts},
+		{name: "status", run: PublishStatusChanges},
+	}
+	// Without the list of nodes every entry would look deleted.
+	if err == nil {
+		tasks = append(tasks, cronTask{name: "disk_cache", run: func(context.Context) error {
+			erased, err := SweepDiskCache(uuids)
+			if erased > 0 {
+				fmt.Println("Erased", erased, "disk cache entries")
+			}
+			return err
+		}})
+	}
+
+	spread := interval * time.Duration(Conf().Data.CronJitter) / 100
+	for uuid := range uuids {
+		var delay time.Duration
+		if spread > 0 {
+			delay = rand.N(spread)
+		}
+		tasks = append(tasks,
+			cronTask{name: "info", uuid: uuid, delay: delay, run: func(ctx context.Context) error {
+				_, err := getInfo(ctx, uuid, true)
+				return err
+			}},
+			cronTask{name: "collection", uuid: uuid, delay: delay, run: func(ctx context.Context) error {
+				_, err := getCollection(ctx, uuid, true)
+				return err
+			}},
+			cronTask{name: "retention", uuid: uuid, delay: delay, run: func(ctx context.Context) error {
+				return RetentionCollectionData(ctx, uuid)
+			}},
+		)
+	}
+	return tasks
+}
+
+// runCronTasks queues every task after its delay and runs them on at most
+// concurrency workers, each within timeout. Once ctx is done no task is
+// queued anymore, those already queued still run and are cancelled grace
+// later. It returns when they are all done.
+func runCronTasks(ctx context.Context, tasks []cronTask, concurrency int, timeout, grace time.Duration) {
+	sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].delay < tasks[j].delay })
+
+	taskCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { time.AfterFunc(grace, cancel) })
+	defer stop()
+
+	queue := make(chan cronTask)
+	var workers sync.WaitGroup
+	for range max(concurrency, 1) {
+		workers.Go(func() {
+			for t := range queue {
+				runCronTask(taskCtx, t, timeout)
+			}
+		})
+	}
+
+	start := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+dispatch:
+	for _, t := range tasks {
+		if d := t.delay - time.Since(start); d > 0 {
+			timer.Reset(d)
+			select {
+			case <-ctx.Done():
+				break dispatch
+			case <-timer.C:
+			}
+		}
+		select {
+		case <-ctx.Done():
+			break dispatch
+		case queue <- t:
+		}
+	}
+	close(queue)
+	workers.Wait()
+}
+
+// runCronTask runs t within timeout, logs its error and records its result.
+func runCronTask(ctx context.Context, t cronTask, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := t.run(ctx)
+	d := time.Since(start)
+
+	result := "ok"
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		result = "timeout"
+	case err != nil:
+		result = "error"
+	}
+	recordCronTask(t.name, result, d)
+
+	if err != nil {
+		if t.uuid != "" {
+			fmt.Println("Error in cron task", t.name, "for uuid:", t.uuid, err)
+		} else {
+			fmt.Println("Error in cron task", t.name+":", err)
+		}
+	}
+}
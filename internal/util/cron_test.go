@@ -0,0 +1,210 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunCronTasksConcurrency(t *testing.T) {
+	var running, peak atomic.Int32
+	tasks := make([]cronTask, 10)
+	for i := range tasks {
+		tasks[i] = cronTask{name: "test_concurrency", run: func(context.Context) error {
+			n := running.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			running.Add(-1)
+			return nil
+		}}
+	}
+
+	runCronTasks(context.Background(), tasks, 3, time.Second, time.Second)
+	if p := peak.Load(); p != 3 {
+		t.Errorf("peak concurrency = %d, want 3", p)
+	}
+	if n := GetCronStats().Tasks["test_concurrency"]["ok"]; n != 10 {
+		t.Errorf("ok tasks = %d, want 10", n)
+	}
+}
+
+func TestRunCronTasksResults(t *testing.T) {
+	tasks := []cronTask{
+		{name: "test_results", run: func(context.Context) error { return nil }},
+		{name: "test_results", uuid: "n1", run: func(context.Context) error { return errors.New("failed") }},
+		{name: "test_results", run: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+	}
+
+	start := time.Now()
+	runCronTasks(context.Background(), tasks, 3, 20*time.Millisecond, time.Second)
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("runCronTasks took %s despite the task timeout", d)
+	}
+
+	stats := GetCronStats()
+	for result, want := range map[string]int64{"ok": 1, "error": 1, "timeout": 1} {
+		if n := stats.Tasks["test_results"][result]; n != want {
+			t.Errorf("%s tasks = %d, want %d", result, n, want)
+		}
+	}
+	if stats.TaskDuration["test_results"] < 20*time.Millisecond {
+		t.Errorf("task duration = %s, want at least the timeout", stats.TaskDuration["test_results"])
+	}
+}
+
+func TestRunCronTasksDelay(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	task := func(name string, delay time.Duration) cronTask {
+		return cronTask{name: "test_delay", delay: delay, run: func(context.Context) error {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			return nil
+		}}
+	}
+
+	start := time.Now()
+	runCronTasks(context.Background(), []cronTask{task("late", 40*time.Millisecond), task("early", 0)}, 1, time.Second, time.Second)
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Errorf("runCronTasks returned after %s, before the delay", d)
+	}
+	if len(order) != 2 || order[0] != "early" {
+		t.Errorf("order = %v, want [early late]", order)
+	}
+
+	// Once stopped, delayed tasks are not queued anymore.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	order = nil
+	runCronTasks(ctx, []cronTask{task("late", time.Hour)}, 1, time.Second, time.Second)
+	if len(order) != 0 {
+		t.Errorf("a delayed task ran after the context was done: %v", order)
+	}
+}
+
+func TestRunCronTasksGrace(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	var stopped atomic.Int64
+	tasks := []cronTask{{name: "test_grace", run: func(taskCtx context.Context) error {
+		close(started)
+		<-ctx.Done()
+		start := time.Now()
+		<-taskCtx.Done()
+		stopped.Store(int64(time.Since(start)))
+		return taskCtx.Err()
+	}}}
+
+	go func() {
+		<-started
+		cancel()
+	}()
+	start := time.Now()
+	runCronTasks(ctx, tasks, 1, time.Minute, 30*time.Millisecond)
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("runCronTasks took %s, the running task was not cancelled", d)
+	}
+	if d := time.Duration(stopped.Load()); d < 20*time.Millisecond {
+		t.Errorf("the task was cancelled %s after the CronJob stopped, want the grace period", d)
+	}
+	if n := GetCronStats().Tasks["test_grace"]["error"]; n != 1 {
+		t.Errorf("cancelled tasks = %d, want 1 error", n)
+	}
+}
+
+func TestCronTasks(t *testing.T) {
+	s := setupTestStore(t)
+	for _, uuid := range []string{"n1", "n2"} {
+		s.hset("hashes", uuid, uuid+".example")
+	}
+
+	setTestConfig(t, func(c *Config) { c.Data.CronJitter = 50 })
+	counts := map[string]int{}
+	for _, task := range cronTasks(time.Minute) {
+		counts[task.name]++
+		if task.delay < 0 || task.delay >= 30*time.Second {
+			t.Errorf("%s delay = %s, want within [0, 30s)", task.name, task.delay)
+		}
+		if task.uuid == "" && task.delay != 0 {
+			t.Errorf("%s is about all nodes but delayed by %s", task.name, task.delay)
+		}
+	}
+	// The names are global, fetched once whatever the number of nodes.
+	want := map[string]int{"names": 1, "alerts": 1, "status": 1, "disk_cache": 1, "info": 2, "collection": 2, "retention": 2}
+	for name, n := range want {
+		if counts[name] != n {
+			t.Errorf("%d %s tasks, want %d", counts[name], name, n)
+		}
+	}
+}
+
+func TestCronTasksContext(t *testing.T) {
+	s := setupTestStore(t)
+	s.hset("hashes", "n1", "n1.example")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// The tasks about all nodes give up once their context is done, so the
+	// task timeout applies to them too.
+	for _, task := range cronTasks(time.Minute) {
+		if task.name != "alerts" && task.name != "status" {
+			continue
+		}
+		if err := task.run(ctx); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s task = %v, want %v", task.name, err, context.Canceled)
+		}
+	}
+}
+
+func TestCronJobStops(t *testing.T) {
+	s := setupTestStore(t)
+	SetupCollectionCache()
+	setTestConfig(t, func(c *Config) { c.Data.CronJitter = 0 })
+	now := time.Now().Unix()
+	err := s.SaveReport(context.Background(), "n1", NodeUpdate{
+		Point:    loadPoint(now, 1),
+		Info:     map[string]string{"Update Time": strconv.FormatInt(now, 10)},
+		Address:  "n1.example",
+		AliveTTL: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runs := GetCronStats().Runs
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		CronJob(ctx)
+		close(done)
+	}()
+	for GetCronStats().Runs == runs {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("CronJob did not return after its context was done")
+	}
+
+	if CollectionCache.Peek("system_monitor:collection:n1") == nil {
+		t.Error("CronJob did not refresh the collection")
+	}
+	if status, _ := s.GetStatus(context.Background(), "n1"); status != "1" {
+		t.Errorf("status = %q, want 1 after PublishStatusChanges", status)
+	}
+}
@@ -4,32 +4,18 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"reflect"
+	"math"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/elliotchance/orderedmap/v3"
-	"github.com/redis/go-redis/v9"
 )
 
-type CollectionData map[string]interface{}
-
-func checkEmpty(v any) bool {
-	rv := reflect.ValueOf(v)
-	switch rv.Kind() {
-	case reflect.Map, reflect.Slice, reflect.Array:
-		return rv.Len() == 0
-	case reflect.Ptr, reflect.Interface:
-		return rv.IsNil()
-	default:
-		zero := reflect.Zero(rv.Type())
-		return reflect.DeepEqual(v, zero.Interface())
-	}
-}
-
 func toFloat64(value interface{}) (float64, error) {
 	switch v := value.(type) {
+	case Number:
+		return v.Float(), nil
 	case float64:
 		return v, nil
 	case float32:
@@ -63,12 +49,12 @@ func GetUUIDs(refresh bool) (map[string]string, error) {
 		return MapStringCache.Get("system_monitor:hashes").Value(), nil
 	}
 
-	data, err := RedisHGetAll(context.Background(), RedisClient, "system_monitor:hashes")
+	data, err := DataStore.ListNodes(context.Background())
 
 	MapStringCache.Set(
 		"system_monitor:hashes",
 		data,
-		time.Duration(GetEnvInt("LOCAL_CACHE_TIME", 300))*time.Second,
+		time.Duration(Conf().Cache.LocalTime)*time.Second,
 	)
 	if err != nil {
 		return nil, err
@@ -86,27 +72,25 @@ func GetCollectionStatus() (*orderedmap.OrderedMap[string, map[string]interface{
 	if err != nil {
 		return nil, err
 	}
+	hidden, _ := GetHiddenNodes(false)
 
 	for uuidKey := range uuids {
+		if _, ok := hidden[uuidKey]; ok {
+			continue
+		}
+
 		latest, err := GetCollectionLatest(uuidKey)
-		if err != nil || len(latest) == 0 {
+		if err != nil || latest.Empty() {
 			fmt.Println(uuidKey, err)
 			continue
 		}
 
 		_info, _ := GetInfo(uuidKey, false)
 
-		i, _ := toFloat64(_info["Update Time"])
-		t := time.Unix(int64(i), 0)
-
-		if t.Before(time.Now().Add(-time.Duration(GetEnvInt("OFFLINE_THRESHOLD", 600)) * time.Second)) {
-			if b, _ := RedisExists(context.Background(), RedisClient, "system_monitor:alive:"+uuidKey); b {
-				online[uuidKey] = latest
-			} else {
-				offline[uuidKey] = latest
-			}
-		} else {
+		if IsOnline(uuidKey, _info) {
 			online[uuidKey] = latest
+		} else {
+			offline[uuidKey] = latest
 		}
 
 		info[uuidKey] = _info
@@ -119,78 +103,153 @@ func GetCollectionStatus() (*orderedmap.OrderedMap[string, map[string]interface{
 	return result, nil
 }
 
+// IsOnline reports whether a node has updated within OFFLINE_THRESHOLD seconds
+// or still holds its alive key.
+func IsOnline(uuid string, info map[string]string) bool {
+	return isOnline(context.Background(), uuid, info)
+}
+
+func isOnline(ctx context.Context, uuid string, info map[string]string) bool {
+	i, _ := toFloat64(info["Update Time"])
+	t := time.Unix(int64(i), 0)
+
+	if t.Before(time.Now().Add(-time.Duration(Conf().Data.OfflineThreshold) * time.Second)) {
+		b, _ := DataStore.IsAlive(ctx, uuid)
+		return b
+	}
+	return true
+}
+
+// GetCollectionByTime returns the points of uuid scored within [start, end],
+// fetching only that range from the store. end <= 0 means now.
 func GetCollectionByTime(uuid string, refresh bool, start int64, end int64) (*orderedmap.OrderedMap[int64, CollectionData], error) {
-	if end < start {
+	if start <= 0 && end <= 0 {
 		return GetCollection(uuid, refresh)
 	}
+	if end <= 0 {
+		end = time.Now().Unix()
+	}
+	if end < start {
+		return nil, fmt.Errorf("invalid time range: %d > %d", start, end)
+	}
 
-	result, err := GetCollection(uuid, refresh)
+	data, err := DataStore.RangePoints(context.Background(), SeriesCollection, uuid, start, end)
 	if err != nil {
-		fmt.Println(err)
 		return nil, err
 	}
 
-	m := orderedmap.NewOrderedMap[int64, CollectionData]()
-	for t, v := range result.AllFromFront() {
-		if t > start && t < end {
-			m.Set(t, v)
-			continue
-		}
-	}
-
-	return m, nil
+	return decodeCollections(data), nil
 }
 
+// GetCollection returns the raw points of uuid from CollectionCache.
+// On a miss, an expired entry or refresh, the cached collection (or the disk
+// cache after a restart) is brought up to date by fetching only the points
+// newer than its last one, and points older than DATA_RETENTION_DAYS are dropped.
 func GetCollection(uuid string, refresh bool) (*orderedmap.OrderedMap[int64, CollectionData], error) {
-	// if CollectionCache == nil {
-	// 	fmt.Println("LocalCacheClient is not initialized")
-	// }
+	return getCollection(context.Background(), uuid, refresh)
+}
 
-	// if !refresh && CollectionCache != nil && CollectionCache.Get("system_monitor:collection:"+uuid) != nil {
-	// 	return CollectionCache.Get("system_monitor:collection:" + uuid).Value(), nil
-	// }
+func getCollection(ctx context.Context, uuid string, refresh bool) (*orderedmap.OrderedMap[int64, CollectionData], error) {
+	key := "system_monitor:collection:" + uuid
 
-	orderedMap := orderedmap.NewOrderedMap[int64, CollectionData]()
+	var cached *orderedmap.OrderedMap[int64, CollectionData]
+	if CollectionCache != nil {
+		if refresh {
+			cached = CollectionCache.Peek(key)
+		} else if collection, fresh := CollectionCache.Get(key); fresh {
+			return collection, nil
+		} else {
+			cached = collection
+		}
+	}
 
-	data, err := RedisZRangeByScoreWithScores(
-		context.Background(),
-		RedisClient,
-		"system_monitor:collection:"+uuid,
-		&redis.ZRangeBy{Min: "0", Max: fmt.Sprint(time.Now().Unix())},
-		!refresh,
-	)
+	if cached == nil && DiskCache != nil {
+		if data, err := GetDiskCachePoints(key); err == nil {
+			cached = decodeCollections(data)
+		}
+	}
+
+	orderedMap, changed, err := updateCollection(ctx, uuid, cached)
 	if err != nil {
-		// CollectionCache.Set(
-		// 	"system_monitor:collection:"+uuid,
-		// 	orderedMap,
-		// 	time.Duration(GetEnvInt("LOCAL_CACHE_TIME", 300))*time.Second,
-		// )
 		return nil, err
 	}
-
-	if len(data) == 0 {
-		// fmt.Println("no data found for uuid:", uuid)
+	if orderedMap.Len() == 0 {
+		// Unknown nodes are not cached, any uuid can be requested.
+		if CollectionCache != nil {
+			CollectionCache.Delete(key)
+		}
 		return nil, fmt.Errorf("no data found for uuid: %s", uuid)
 	}
 
+	if CollectionCache != nil {
+		CollectionCache.Set(key, orderedMap, time.Duration(Conf().Cache.LocalTime)*time.Second)
+	}
+	if changed && DiskCache != nil {
+		SetDiskCachePoints(key, encodeCollections(orderedMap))
+	}
+	return orderedMap, nil
+}
+
+// updateCollection returns cached with the points reported after its last one
+// appended and the expired ones dropped. cached is returned as is when nothing
+// changed, otherwise a copy is made.
+func updateCollection(ctx context.Context, uuid string, cached *orderedmap.OrderedMap[int64, CollectionData]) (*orderedmap.OrderedMap[int64, CollectionData], bool, error) {
+	now := time.Now().Unix()
+	cutoff := now - rawRetention()
+
+	from := cutoff + 1
+	if cached != nil && cached.Len() > 0 && cached.Back().Key >= from {
+		from = cached.Back().Key + 1
+	}
+	data, err := DataStore.RangePoints(ctx, SeriesCollection, uuid, from, now)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if cached != nil && len(data) == 0 && (cached.Len() == 0 || cached.Front().Key > cutoff) {
+		return cached, false, nil
+	}
+
+	result := orderedmap.NewOrderedMap[int64, CollectionData]()
+	if cached != nil {
+		for t, v := range cached.AllFromFront() {
+			if t > cutoff {
+				result.Set(t, v)
+			}
+		}
+	}
+	for t, v := range decodeCollections(data).AllFromFront() {
+		result.Set(t, v)
+	}
+	return result, true, nil
+}
+
+func decodeCollections(data []Point) *orderedmap.OrderedMap[int64, CollectionData] {
+	orderedMap := orderedmap.NewOrderedMap[int64, CollectionData]()
 	for _, item := range data {
-		d, err := UnmarshalJSONData(item.Member.(string))
+		d, err := UnmarshalJSONData(item.Data)
 
 		if err != nil {
 			fmt.Println(err)
 			continue
 		}
 
-		orderedMap.Set(int64(item.Score), *d)
+		orderedMap.Set(item.Time, *d)
 	}
+	return orderedMap
+}
 
-	// CollectionCache.Set(
-	// 	"system_monitor:collection:"+uuid,
-	// 	orderedMap,
-	// 	time.Duration(GetEnvInt("LOCAL_CACHE_TIME", 300))*time.Second,
-	// )
-
-	return orderedMap, nil
+func encodeCollections(collections *orderedmap.OrderedMap[int64, CollectionData]) []Point {
+	result := make([]Point, 0, collections.Len())
+	for t, v := range collections.AllFromFront() {
+		data, err := json.Marshal(v)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		result = append(result, Point{Time: t, Data: string(data)})
+	}
+	return result
 }
 
 func GetCollectionLatest(uuid string) (CollectionData, error) {
@@ -204,173 +263,424 @@ func GetCollectionLatest(uuid string) (CollectionData, error) {
 		return CollectionData{}, fmt.Errorf("no data found for uuid: %s", uuid)
 	}
 
-	fmt.Println(latest)
 	return latest.Value, nil
 }
 
-func CollectionFormat(collections *orderedmap.OrderedMap[int64, CollectionData], name string) map[string]interface{} {
-	// fmt.Println(collections.Front())
+func collectionTime(score int64) string {
+	return time.Unix(score, 0).Format("01-02 15:04")
+}
+
+// seriesSet builds one series per key (mountpoint, sensor, target...) that
+// stays aligned with the time axis: points missing a key are null.
+type seriesSet struct {
+	points int
+	values map[string][]interface{}
+}
+
+func newSeriesSet() *seriesSet {
+	return &seriesSet{values: map[string][]interface{}{}}
+}
+
+// next starts a new point on the time axis.
+func (s *seriesSet) next() {
+	s.points++
+}
+
+func (s *seriesSet) set(key string, v interface{}) {
+	values := s.values[key]
+	for len(values) < s.points-1 {
+		values = append(values, nil)
+	}
+	s.values[key] = append(values, v)
+}
+
+func (s *seriesSet) result() map[string]interface{} {
+	result := make(map[string]interface{}, len(s.values))
+	for key, values := range s.values {
+		for len(values) < s.points {
+			values = append(values, nil)
+		}
+		result[key] = values
+	}
+	return result
+}
+
+func seriesSetsResult(sets map[string]map[string]*seriesSet) map[string]interface{} {
+	result := make(map[string]interface{}, len(sets))
+	for dir, fields := range sets {
+		r := make(map[string]interface{}, len(fields))
+		for field, s := range fields {
+			r[field] = s.result()
+		}
+		result[dir] = r
+	}
+	return result
+}
+
+// counterRates turns cumulative counters into per second rates between
+// consecutive samples of each key. The first sample of a key has no rate (nil).
+type counterRates struct {
+	last map[string]counterSample
+}
+
+type counterSample struct {
+	time  int64
+	value float64
+}
+
+func newCounterRates() *counterRates {
+	return &counterRates{last: map[string]counterSample{}}
+}
+
+func (r *counterRates) rate(key string, t int64, v float64) interface{} {
+	prev, ok := r.last[key]
+	r.last[key] = counterSample{t, v}
+	if !ok || t <= prev.time {
+		return nil
+	}
+	return counterDelta(prev.value, v) / float64(t-prev.time)
+}
+
+// kilobytes converts a byte rate, keeping missing rates nil.
+func kilobytes(rate interface{}) interface{} {
+	if v, ok := rate.(float64); ok {
+		return v / 1024
+	}
+	return rate
+}
+
+// counterDelta returns how much a counter grew from prev to cur. A 32-bit
+// counter close to its limit that drops close to zero wrapped around; any
+// other decrease means the counter was reset, e.g. by a reboot, and counted
+// up from zero since.
+func counterDelta(prev, cur float64) float64 {
+	if cur >= prev {
+		return cur - prev
+	}
+	const wrap = math.MaxUint32 + 1
+	if prev < wrap && prev > wrap*3/4 && cur < wrap/4 {
+		return wrap - prev + cur
+	}
+	return cur
+}
+
+func CollectionFormat(collections *orderedmap.OrderedMap[int64, CollectionData], name string, agg ...Aggregation) map[string]interface{} {
 	result := map[string]interface{}{}
 
-	if collections.Len() == 0 || checkEmpty(collections.Back().Value[name]) {
+	for _, a := range agg {
+		collections = Downsample(collections, name, a)
+	}
+
+	if collections == nil || collections.Len() == 0 || !collections.Back().Value.Has(name) {
 		return result
 	}
+	times := []string{}
+
 	switch name {
 	case "Memory":
+		mem, swap := []float64{}, []float64{}
+		for score, collection := range collections.AllFromFront() {
+			if collection.Memory == nil {
+				continue
+			}
+			times = append(times, collectionTime(score))
+			mem = append(mem, collection.Memory.Mem.Used.Float())
+			swap = append(swap, collection.Memory.Swap.Used.Float())
+		}
 		result = map[string]interface{}{
-			"time": []string{},
-			"value": map[string]interface{}{
-				"Mem":  []string{},
-				"Swap": []string{},
-			},
+			"time":  times,
+			"value": map[string]interface{}{"Mem": mem, "Swap": swap},
 		}
 
+	case "Network":
+		// RX/TX are the cumulative totals, rate the throughput per second and
+		// interfaces the rates per interface: {RX: {kilobytes: {eth0: [...]}}}.
+		rx := map[string][]float64{"megabytes": {}, "packets": {}}
+		tx := map[string][]float64{"megabytes": {}, "packets": {}}
+		rate := map[string]map[string][]interface{}{
+			"RX": {"kilobytes": {}, "packets": {}},
+			"TX": {"kilobytes": {}, "packets": {}},
+		}
+		interfaces := map[string]map[string]*seriesSet{
+			"RX": {"kilobytes": newSeriesSet(), "packets": newSeriesSet()},
+			"TX": {"kilobytes": newSeriesSet(), "packets": newSeriesSet()},
+		}
+		rates := newCounterRates()
 		for score, collection := range collections.AllFromFront() {
-
-			result["time"] = append(result["time"].([]string), time.Unix(score, 0).Format("01-02 15:04"))
-			result["value"].(map[string]interface{})["Mem"] = append(result["value"].(map[string]interface{})["Mem"].([]string), collection[name].(map[string]interface{})["Mem"].(map[string]interface{})["used"].(string))
-			result["value"].(map[string]interface{})["Swap"] = append(result["value"].(map[string]interface{})["Swap"].([]string), collection[name].(map[string]interface{})["Swap"].(map[string]interface{})["used"].(string))
-
+			if collection.Network == nil {
+				continue
+			}
+			n := collection.Network
+			times = append(times, collectionTime(score))
+			rx["megabytes"] = append(rx["megabytes"], n.RX.Bytes.Float()/megabyte)
+			rx["packets"] = append(rx["packets"], n.RX.Packets.Float()/1000)
+			tx["megabytes"] = append(tx["megabytes"], n.TX.Bytes.Float()/megabyte)
+			tx["packets"] = append(tx["packets"], n.TX.Packets.Float()/1000)
+
+			for dir, stat := range map[string]NetworkStat{"RX": n.RX, "TX": n.TX} {
+				r := rate[dir]
+				r["kilobytes"] = append(r["kilobytes"], kilobytes(rates.rate(dir+".bytes", score, stat.Bytes.Float())))
+				r["packets"] = append(r["packets"], rates.rate(dir+".packets", score, stat.Packets.Float()))
+
+				series := interfaces[dir]
+				for _, s := range series {
+					s.next()
+				}
+				for name, i := range n.Interfaces {
+					is := map[string]NetworkStat{"RX": i.RX, "TX": i.TX}[dir]
+					key := "Interfaces." + name + "." + dir
+					series["kilobytes"].set(name, kilobytes(rates.rate(key+".bytes", score, is.Bytes.Float())))
+					series["packets"].set(name, rates.rate(key+".packets", score, is.Packets.Float()))
+				}
+			}
 		}
-
-	case "Network":
-		result = map[string]interface{}{
-			"time": []string{},
-			"RX": map[string]interface{}{
-				"megabytes": []float64{},
-				"packets":   []float64{},
-			},
-			"TX": map[string]interface{}{
-				"megabytes": []float64{},
-				"packets":   []float64{},
-			},
+		result = map[string]interface{}{"time": times, "RX": rx, "TX": tx, "rate": rate}
+		if len(interfaces["RX"]["kilobytes"].values) > 0 {
+			result["interfaces"] = seriesSetsResult(interfaces)
 		}
 
+	case "IO":
+		// read/write are the cumulative totals, rate the activity per second and
+		// devices the rates per block device: {read: {kilobytes: {sda: [...]}}}.
+		read := map[string][]float64{"counts": {}, "megabytes": {}, "time_ms": {}}
+		write := map[string][]float64{"counts": {}, "megabytes": {}, "time_ms": {}}
+		rate := map[string]map[string][]interface{}{
+			"read":  {"counts": {}, "kilobytes": {}, "time_ms": {}},
+			"write": {"counts": {}, "kilobytes": {}, "time_ms": {}},
+		}
+		devices := map[string]map[string]*seriesSet{
+			"read":  {"counts": newSeriesSet(), "kilobytes": newSeriesSet(), "time_ms": newSeriesSet()},
+			"write": {"counts": newSeriesSet(), "kilobytes": newSeriesSet(), "time_ms": newSeriesSet()},
+		}
+		rates := newCounterRates()
 		for score, collection := range collections.AllFromFront() {
-
-			result["time"] = append(result["time"].([]string), time.Unix(score, 0).Format("01-02 15:04"))
-			rx_megabytes, err1 := toFloat64(collection[name].(map[string]interface{})["RX"].(map[string]interface{})["bytes"])
-			rx_packets, err2 := toFloat64(collection[name].(map[string]interface{})["RX"].(map[string]interface{})["packets"])
-			tx_megabytes, err3 := toFloat64(collection[name].(map[string]interface{})["TX"].(map[string]interface{})["bytes"])
-			tx_packets, err4 := toFloat64(collection[name].(map[string]interface{})["TX"].(map[string]interface{})["packets"])
-
-			if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
-				fmt.Println("Error converting to float64:", err1, err2, err3, err4)
+			if collection.IO == nil {
 				continue
 			}
-			result["RX"].(map[string]interface{})["megabytes"] = append(result["RX"].(map[string]interface{})["megabytes"].([]float64), rx_megabytes/1048576)
-			result["RX"].(map[string]interface{})["packets"] = append(result["RX"].(map[string]interface{})["packets"].([]float64), rx_packets/1000)
-			result["TX"].(map[string]interface{})["megabytes"] = append(result["TX"].(map[string]interface{})["megabytes"].([]float64), tx_megabytes/1048576)
-			result["TX"].(map[string]interface{})["packets"] = append(result["TX"].(map[string]interface{})["packets"].([]float64), tx_packets/1000)
+			io := collection.IO
+			times = append(times, collectionTime(score))
+			read["counts"] = append(read["counts"], io.Read.Count.Float())
+			read["megabytes"] = append(read["megabytes"], io.Read.Bytes.Float()/megabyte)
+			read["time_ms"] = append(read["time_ms"], io.Read.Time.Float())
+			write["counts"] = append(write["counts"], io.Write.Count.Float())
+			write["megabytes"] = append(write["megabytes"], io.Write.Bytes.Float()/megabyte)
+			write["time_ms"] = append(write["time_ms"], io.Write.Time.Float())
+
+			for dir, stat := range map[string]IOStat{"read": io.Read, "write": io.Write} {
+				r := rate[dir]
+				r["counts"] = append(r["counts"], rates.rate(dir+".count", score, stat.Count.Float()))
+				r["kilobytes"] = append(r["kilobytes"], kilobytes(rates.rate(dir+".bytes", score, stat.Bytes.Float())))
+				r["time_ms"] = append(r["time_ms"], rates.rate(dir+".time", score, stat.Time.Float()))
+
+				series := devices[dir]
+				for _, s := range series {
+					s.next()
+				}
+				for name, dev := range io.Devices {
+					ds := map[string]IOStat{"read": dev.Read, "write": dev.Write}[dir]
+					key := "Devices." + name + "." + dir
+					series["counts"].set(name, rates.rate(key+".count", score, ds.Count.Float()))
+					series["kilobytes"].set(name, kilobytes(rates.rate(key+".bytes", score, ds.Bytes.Float())))
+					series["time_ms"].set(name, rates.rate(key+".time", score, ds.Time.Float()))
+				}
+			}
+		}
+		result = map[string]interface{}{"time": times, "read": read, "write": write, "rate": rate}
+		if len(devices["read"]["counts"].values) > 0 {
+			result["devices"] = seriesSetsResult(devices)
 		}
 
 	case "Disk":
+		//disk:{mountpoint: used}, with total, free and percent alike
+		used, total, free, percent := newSeriesSet(), newSeriesSet(), newSeriesSet(), newSeriesSet()
+		for score, collection := range collections.AllFromFront() {
+			if len(collection.Disk) == 0 {
+				continue
+			}
+			times = append(times, collectionTime(score))
+			for _, s := range []*seriesSet{used, total, free, percent} {
+				s.next()
+			}
+			for mountpoint, stat := range collection.Disk {
+				used.set(mountpoint, stat.Used.Float())
+				total.set(mountpoint, stat.Total.Float())
+				free.set(mountpoint, stat.Free.Float())
+				percent.set(mountpoint, stat.Percent.Float())
+			}
+		}
 		result = map[string]interface{}{
-			"time":  []string{},
-			"value": map[string]interface{}{},
+			"time":     times,
+			"value":    used.result(),
+			"total":    total.result(),
+			"free":     free.result(),
+			"percent":  percent.result(),
+			"forecast": ForecastDisk(collections),
 		}
 
+	case "Load", "Thermal":
+		//load:{idle, system....}
+		//thermal:{cpu, gpu....}
+		values := newSeriesSet()
 		for score, collection := range collections.AllFromFront() {
-			// d := reflect.ValueOf(collection)
-
-			result["time"] = append(result["time"].([]string), time.Unix(score, 0).Format("01-02 15:04"))
-
-			for key, _v := range collection[name].(map[string]interface{}) {
-
-				// get typed reference to result["value"]
-				resValue := result["value"].(map[string]interface{})
-
-				if resValue[key] == nil {
-					resValue[key] = make([]string, 0)
+			if !collection.Has(name) {
+				continue
+			}
+			times = append(times, collectionTime(score))
+			values.next()
+			switch name {
+			case "Load":
+				for key, v := range collection.Load {
+					values.set(key, v.Float())
+				}
+			case "Thermal":
+				for key, v := range collection.Thermal {
+					values.set(key, v.Float())
 				}
-
-				resValue[key] = append(resValue[key].([]string), _v.(map[string]interface{})["used"].(string))
 			}
 		}
+		result = map[string]interface{}{"time": times, "value": values.result()}
 
-	case "IO":
-		result = map[string]interface{}{
-			"time": []string{},
-			"read": map[string]interface{}{
-				"counts":    []float64{},
-				"megabytes": []float64{},
-				"time_ms":   []float64{},
-			},
-			"write": map[string]interface{}{
-				"counts":    []float64{},
-				"megabytes": []float64{},
-				"time_ms":   []float64{},
-			},
-		}
+	case "Ping":
+		result = pingFormat(collections)
 
-		for score, collection := range collections.AllFromFront() {
-			// d := reflect.ValueOf(collection)
+	case "Battery":
+		result = batteryFormat(collections)
+	}
 
-			result["time"] = append(result["time"].([]string), time.Unix(score, 0).Format("01-02 15:04"))
+	return result
+}
 
-			read_counts, err1 := toFloat64(collection[name].(map[string]interface{})["read"].(map[string]interface{})["count"])
-			read_megabytes, err2 := toFloat64(collection[name].(map[string]interface{})["read"].(map[string]interface{})["bytes"])
-			read_time_ms, err3 := toFloat64(collection[name].(map[string]interface{})["read"].(map[string]interface{})["time"])
-			write_counts, err4 := toFloat64(collection[name].(map[string]interface{})["write"].(map[string]interface{})["count"])
-			write_megabytes, err5 := toFloat64(collection[name].(map[string]interface{})["write"].(map[string]interface{})["bytes"])
-			write_time_ms, err6 := toFloat64(collection[name].(map[string]interface{})["write"].(map[string]interface{})["time"])
+// batteryFormat builds the percent series with the charge state and power
+// source of every point, plus the latest values for display.
+func batteryFormat(collections *orderedmap.OrderedMap[int64, CollectionData]) map[string]interface{} {
+	times := []string{}
+	percents := []float64{}
+	states := []interface{}{}
+	plugged := []interface{}{}
+	latest := map[string]interface{}{}
+
+	for score, collection := range collections.AllFromFront() {
+		b := collection.Battery
+		if b == nil {
+			continue
+		}
+		times = append(times, collectionTime(score))
+		percents = append(percents, b.Percent.Float())
 
-			if err1 != nil || err2 != nil || err3 != nil || err4 != nil || err5 != nil || err6 != nil {
-				fmt.Println("Error converting to float64:", err1, err2, err3, err4, err5, err6)
-				continue
-			}
+		var state, p, secsleft interface{}
+		if s := b.State(); s != "" {
+			state = s
+		}
+		if b.PowerPlugged != nil {
+			p = *b.PowerPlugged
+		}
+		// psutil reports unknown or unlimited time left as negative values
+		if b.Secsleft != nil && *b.Secsleft >= 0 {
+			secsleft = b.Secsleft.Float()
+		}
+		states = append(states, state)
+		plugged = append(plugged, p)
+		latest = map[string]interface{}{
+			"percent":  b.Percent.Float(),
+			"state":    state,
+			"plugged":  p,
+			"secsleft": secsleft,
+		}
+	}
 
-			result["read"].(map[string]interface{})["counts"] = append(result["read"].(map[string]interface{})["counts"].([]float64), read_counts)
-			result["read"].(map[string]interface{})["megabytes"] = append(result["read"].(map[string]interface{})["megabytes"].([]float64), read_megabytes/1048576)
-			result["read"].(map[string]interface{})["time_ms"] = append(result["read"].(map[string]interface{})["time_ms"].([]float64), read_time_ms)
+	if len(times) == 0 {
+		return map[string]interface{}{}
+	}
 
-			result["write"].(map[string]interface{})["counts"] = append(result["write"].(map[string]interface{})["counts"].([]float64), write_counts)
-			result["write"].(map[string]interface{})["megabytes"] = append(result["write"].(map[string]interface{})["megabytes"].([]float64), write_megabytes/1048576)
-			result["write"].(map[string]interface{})["time_ms"] = append(result["write"].(map[string]interface{})["time_ms"].([]float64), write_time_ms)
+	return map[string]interface{}{
+		"time":    times,
+		"value":   map[string]interface{}{"percent": percents},
+		"state":   states,
+		"plugged": plugged,
+		"latest":  latest,
+	}
+}
 
-		}
+type pingStats struct {
+	min, max, sum float64
+	samples       int
+	loss          float64
+	lossSamples   int
+}
 
-	case "Load", "Thermal":
-		result = map[string]interface{}{
-			"time":  []string{},
-			"value": map[string]interface{}{},
+// pingFormat builds the latency and loss series of every target plus
+// min/avg/max latency and average loss over the whole range.
+// Points missing a target or with all probes lost are null so charts show a gap.
+func pingFormat(collections *orderedmap.OrderedMap[int64, CollectionData]) map[string]interface{} {
+	times := []string{}
+	latencies := newSeriesSet()
+	losses := newSeriesSet()
+	stats := map[string]*pingStats{}
+
+	for score, collection := range collections.AllFromFront() {
+		if len(collection.Ping) == 0 {
+			continue
 		}
+		times = append(times, collectionTime(score))
+		latencies.next()
+		losses.next()
+
+		for target, t := range collection.Ping {
+			st, ok := stats[target]
+			if !ok {
+				st = &pingStats{}
+				stats[target] = st
+			}
 
-		for score, collection := range collections.AllFromFront() {
-			if checkEmpty(collection) || checkEmpty(collection[name]) {
+			losses.set(target, t.Loss.Float())
+			st.lossSamples++
+			st.loss += t.Loss.Float()
+
+			if t.Latency == nil {
+				latencies.set(target, nil)
 				continue
 			}
-			result["time"] = append(result["time"].([]string), time.Unix(score, 0).Format("01-02 15:04"))
-			// fmt.Println(collection[name])
-			for key, _v := range collection[name].(map[string]interface{}) {
-				//load:{idle, system....}
-				//thermal:{cpu, gpu....}
-				//generate value []string for the key inside the result: result["value"][key]
-				resValue := result["value"].(map[string]interface{})
-				if resValue[key] == nil {
-					resValue[key] = make([]float64, 0)
-				}
-				//set the value to the result["value"][key]
-				floatVal, err := toFloat64(_v)
-				if err != nil {
-					fmt.Println("Error converting to float64:", err)
-				}
-				resValue[key] = append(resValue[key].([]float64), floatVal)
+			latency := t.Latency.Float()
+			latencies.set(target, latency)
+			if st.samples == 0 || latency < st.min {
+				st.min = latency
 			}
-
+			if st.samples == 0 || latency > st.max {
+				st.max = latency
+			}
+			st.sum += latency
+			st.samples++
 		}
+	}
+
+	if len(stats) == 0 {
+		return map[string]interface{}{}
+	}
 
-		if checkEmpty(result["value"]) {
-			result = map[string]interface{}{}
+	summary := map[string]interface{}{}
+	for target, st := range stats {
+		item := map[string]interface{}{"min": nil, "avg": nil, "max": nil, "loss": st.loss / float64(st.lossSamples)}
+		if st.samples > 0 {
+			item["min"] = st.min
+			item["avg"] = st.sum / float64(st.samples)
+			item["max"] = st.max
 		}
-		// default:
-		// 	return result
+		summary[target] = item
 	}
 
-	return result
+	return map[string]interface{}{
+		"time":  times,
+		"value": latencies.result(),
+		"loss":  losses.result(),
+		"stats": summary,
+	}
 }
 
 func GetDisplayName(refresh bool) (map[string]string, error) {
+	return getDisplayName(context.Background(), refresh)
+}
+
+func getDisplayName(ctx context.Context, refresh bool) (map[string]string, error) {
 	if MapStringCache == nil {
 		fmt.Println("MapStringCache is not initialized")
 	}
@@ -378,21 +688,25 @@ func GetDisplayName(refresh bool) (map[string]string, error) {
 	if !refresh && MapStringCache != nil && MapStringCache.Get("system_monitor:name") != nil {
 		return MapStringCache.Get("system_monitor:name").Value(), nil
 	}
-	data, err := RedisHGetAll(context.Background(), GetRedisClient(), "system_monitor:name")
+	data, err := DataStore.GetNames(ctx)
 	if err != nil {
-		fmt.Println("Error getting name from Redis:", err)
+		fmt.Println("Error getting name from store:", err)
 		return map[string]string{}, err
 	}
 
 	MapStringCache.Set(
 		"system_monitor:name",
 		data,
-		time.Duration(GetEnvInt("LOCAL_CACHE_TIME", 300))*time.Second,
+		time.Duration(Conf().Cache.LocalTime)*time.Second,
 	)
 	return data, nil
 }
 
 func GetInfo(uuid string, refresh bool) (map[string]string, error) {
+	return getInfo(context.Background(), uuid, refresh)
+}
+
+func getInfo(ctx context.Context, uuid string, refresh bool) (map[string]string, error) {
 	if MapStringCache == nil {
 		fmt.Println("MapStringCache is not initialized")
 	}
@@ -401,12 +715,12 @@ func GetInfo(uuid string, refresh bool) (map[string]string, error) {
 		return MapStringCache.Get("system_monitor:info:" + uuid).Value(), nil
 	}
 
-	data, err := RedisHGetAll(context.Background(), GetRedisClient(), "system_monitor:info:"+uuid)
+	data, err := DataStore.GetInfo(ctx, uuid)
 	if err != nil || len(data) == 0 {
 		// MapStringCache.Set(
 		// 	"system_monitor:info:"+uuid,
 		// 	data,
-		// 	time.Duration(GetEnvInt("LOCAL_CACHE_TIME", 300))*time.Second,
+		// 	time.Duration(Conf().Cache.LocalTime)*time.Second,
 		// )
 		// fmt.Println("Error getting info from Redis:", err)
 		return map[string]string{}, err
@@ -415,52 +729,21 @@ func GetInfo(uuid string, refresh bool) (map[string]string, error) {
 	MapStringCache.Set(
 		"system_monitor:info:"+uuid,
 		data,
-		time.Duration(GetEnvInt("LOCAL_CACHE_TIME", 300))*time.Second,
+		time.Duration(Conf().Cache.LocalTime)*time.Second,
 	)
 	return data, nil
 }
 
-func RetentionCollectionData(uuid string) {
-	ctx := context.Background()
-	retentionDays := GetEnvInt("DATA_RETENTION_DAYS", 7)
-	cutoffTimestamp := time.Now().AddDate(0, 0, -retentionDays).Unix()
-	RedisZRemRangeByScore(
-		ctx,
-		RedisClient,
-		"system_monitor:collection:"+uuid,
-		"-inf",
-		fmt.Sprint(cutoffTimestamp),
-	)
-
-	// if err != nil {
-	// 	fmt.Println("Error during data retention for uuid:", uuid, err)
-	// } else {
-	// 	fmt.Println("Data retention completed for uuid:", uuid)
-	// }
-}
-
-func CronJob() {
-	for {
-		uuids, _ := GetUUIDs(true)
-		for uuidKey := range uuids {
-			go GetInfo(uuidKey, true)
-			go GetCollection(uuidKey, true)
-			go GetDisplayName(true)
-			go RetentionCollectionData(uuidKey)
-		}
-		// fmt.Printf("[current]MapStringCache size: %d, CollectionStatusCache size: %d, MapStringCache size: %d.\n", MapStringCache.GetSize(), CollectionStatusCache.GetSize(), MapStringCache.GetSize())
-		// fmt.Printf("[dropped]MapStringCache size: %d, CollectionStatusCache size: %d, MapStringCache size: %d.\n", MapStringCache.GetDropped(), CollectionStatusCache.GetDropped(), MapStringCache.GetDropped())
-		// fmt.Printf("[current]MapStringCache size: %d, CollectionStatusCache size: %d, MapStringCache size: %d.\n", unsafe.Sizeof(MapStringCache), unsafe.Sizeof(CollectionStatusCache), unsafe.Sizeof(MapStringCache))
-
-		// var m runtime.MemStats
-		// runtime.ReadMemStats(&m)
-		// fmt.Printf("Alloc: %d KiB, HeapSys: %d KiB, PauseTotalNs: %d ns\n",
-		// 	m.Alloc>>10, m.HeapSys>>10, m.PauseTotalNs)
-		// fmt.Printf("HeapIdle: %d KiB, HeapInuse: %d KiB, PauseTotalNs: %d ns\n",
-		// 	m.HeapIdle>>10, m.HeapInuse>>10, m.PauseTotalNs)
-
-		time.Sleep(time.Duration(GetEnvInt("CRON_JOB_INTERVAL", 60)) * time.Second)
+// RetentionCollectionData rolls up the points of uuid, then drops those older
+// than DATA_RETENTION_DAYS.
+func RetentionCollectionData(ctx context.Context, uuid string) error {
+	// Roll points up before they are dropped.
+	if err := CompactCollectionData(ctx, uuid); err != nil {
+		return fmt.Errorf("compact: %w", err)
 	}
+
+	cutoffTimestamp := time.Now().Unix() - rawRetention()
+	return DataStore.Retain(ctx, SeriesCollection, uuid, cutoffTimestamp)
 }
 
 func IconNameFormat(v any) string {
@@ -0,0 +1,343 @@
+package util
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/elliotchance/orderedmap/v3"
+)
+
+// testCollections decodes one point per minute from agent style JSON.
+func testCollections(t *testing.T, points ...string) *orderedmap.OrderedMap[int64, CollectionData] {
+	t.Helper()
+	result := orderedmap.NewOrderedMap[int64, CollectionData]()
+	for i, p := range points {
+		d, err := UnmarshalJSONData(p)
+		if err != nil {
+			t.Fatalf("point %d: %v", i, err)
+		}
+		result.Set(1700000040+int64(i)*60, *d)
+	}
+	return result
+}
+
+func TestIconNameFormat(t *testing.T) {
+	tests := map[string]string{
+		"Ubuntu 22.04.3 LTS":                  "ubuntu",
+		"Debian GNU/Linux 12 (bookworm)":      "debian",
+		"Red Hat Enterprise Linux":            "linux",
+		"RedHat 9":                            "redhat",
+		"Intel(R) Xeon(R) CPU E5-2680":        "intel",
+		"AMD EPYC 7B13":                       "amd",
+		"ARMv7 Processor rev 5 (v7l)":         "arm",
+		"Cortex-A53":                          "arm",
+		"ImmortalWrt 23.05":                   "openwrt",
+		"QWRT":                                "openwrt",
+		"Raspbian GNU/Linux 11":               "raspberrypi",
+		"Alpine Linux v3.19":                  "alpine linux",
+		"QEMU Virtual CPU version 2.5+":       "qemu",
+		"Microsoft Windows Server 2022":       "windows",
+		"Arch Linux":                          "linux",
+		"":                                    "linux",
+		"Qualcomm Technologies, Inc SM8250":   "qualcomm",
+		"MediaTek MT6789":                     "mediatek",
+		"Android 14 (aarch64, Linux 5.15.94)": "android",
+	}
+	for in, want := range tests {
+		if got := IconNameFormat(in); got != want {
+			t.Errorf("IconNameFormat(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestToFloat64(t *testing.T) {
+	tests := []struct {
+		in      interface{}
+		want    float64
+		wantErr bool
+	}{
+		{Number(1.5), 1.5, false},
+		{2.5, 2.5, false},
+		{float32(0.5), 0.5, false},
+		{3, 3, false},
+		{int64(4), 4, false},
+		{"1700000000", 1700000000, false},
+		{"abc", 0, true},
+		{true, 0, true},
+		{nil, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := toFloat64(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("toFloat64(%#v) = %v, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestCollectionFormatMissingSection(t *testing.T) {
+	c := testCollections(t, `{"Load":{"user":1}}`)
+	for _, name := range []string{"Memory", "Disk", "Network", "IO", "Thermal", "Battery", "Ping"} {
+		if got := CollectionFormat(c, name); len(got) != 0 {
+			t.Errorf("CollectionFormat(%s) = %v, want empty", name, got)
+		}
+	}
+	if got := CollectionFormat(nil, "Load"); len(got) != 0 {
+		t.Errorf("CollectionFormat(nil) = %v, want empty", got)
+	}
+}
+
+func TestCollectionFormatMemory(t *testing.T) {
+	// Sizes are sent as strings or numbers, points without the section are skipped.
+	c := testCollections(t,
+		`{"Memory":{"Mem":{"total":"1024.00","used":"512.50"},"Swap":{"total":0,"used":0}}}`,
+		`{"Load":{"user":1}}`,
+		`{"Memory":{"Mem":{"total":1024,"used":600},"Swap":{"total":"256","used":"12"}}}`,
+	)
+	got := CollectionFormat(c, "Memory")
+	if times := got["time"].([]string); len(times) != 2 {
+		t.Errorf("time = %v, want 2 points", times)
+	}
+	want := map[string]interface{}{"Mem": []float64{512.5, 600}, "Swap": []float64{0, 12}}
+	if !reflect.DeepEqual(got["value"], want) {
+		t.Errorf("value = %v, want %v", got["value"], want)
+	}
+}
+
+func TestCollectionFormatLoad(t *testing.T) {
+	// A key missing from a point is null so every series stays aligned with time.
+	c := testCollections(t,
+		`{"Load":{"user":1,"system":"2"}}`,
+		`{"Load":{"user":3}}`,
+		`{"Load":{"user":5,"system":6,"iowait":7}}`,
+	)
+	got := CollectionFormat(c, "Load")["value"]
+	want := map[string]interface{}{
+		"user":   []interface{}{1.0, 3.0, 5.0},
+		"system": []interface{}{2.0, nil, 6.0},
+		"iowait": []interface{}{nil, nil, 7.0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("value = %v, want %v", got, want)
+	}
+}
+
+func TestCollectionFormatDisk(t *testing.T) {
+	c := testCollections(t,
+		`{"Disk":{"/":{"total":"100","used":"40","free":"60","percent":40}}}`,
+		`{"Disk":{"/":{"total":"100","used":"50","free":"50","percent":50},"/data":{"total":200,"used":20,"free":180,"percent":10}}}`,
+	)
+	got := CollectionFormat(c, "Disk")
+	tests := map[string]map[string]interface{}{
+		"value":   {"/": []interface{}{40.0, 50.0}, "/data": []interface{}{nil, 20.0}},
+		"total":   {"/": []interface{}{100.0, 100.0}, "/data": []interface{}{nil, 200.0}},
+		"free":    {"/": []interface{}{60.0, 50.0}, "/data": []interface{}{nil, 180.0}},
+		"percent": {"/": []interface{}{40.0, 50.0}, "/data": []interface{}{nil, 10.0}},
+	}
+	for key, want := range tests {
+		if !reflect.DeepEqual(got[key], want) {
+			t.Errorf("%s = %v, want %v", key, got[key], want)
+		}
+	}
+	forecast := got["forecast"].(map[string]DiskForecast)
+	if f := forecast["/"]; f.Used != 50 || f.Growth != nil {
+		t.Errorf("forecast of a short history = %+v, want no growth", f)
+	}
+}
+
+func TestCollectionFormatNetwork(t *testing.T) {
+	c := testCollections(t,
+		`{"Network":{"RX":{"bytes":1048576,"packets":1000},"TX":{"bytes":"2097152","packets":"2000"}}}`,
+		`{"Network":{"RX":{"bytes":1110016,"packets":1600},"TX":{"bytes":2097152,"packets":2000}}}`,
+		// RX wrapped around a 32-bit counter, TX was reset.
+		`{"Network":{"RX":{"bytes":4294967000,"packets":1600},"TX":{"bytes":1024,"packets":10}}}`,
+		`{"Network":{"RX":{"bytes":1024,"packets":1600},"TX":{"bytes":2048,"packets":10}}}`,
+	)
+	got := CollectionFormat(c, "Network")
+
+	rx := got["RX"].(map[string][]float64)
+	if want := []float64{1, 1110016.0 / megabyte, 4294967000.0 / megabyte, 1024.0 / megabyte}; !reflect.DeepEqual(rx["megabytes"], want) {
+		t.Errorf("RX megabytes = %v, want %v", rx["megabytes"], want)
+	}
+	if want := []float64{1, 1.6, 1.6, 1.6}; !reflect.DeepEqual(rx["packets"], want) {
+		t.Errorf("RX packets = %v, want %v", rx["packets"], want)
+	}
+
+	rate := got["rate"].(map[string]map[string][]interface{})
+	rxRate := rate["RX"]["kilobytes"]
+	if rxRate[0] != nil || rxRate[1] != 1.0 {
+		t.Errorf("RX rate = %v, want [nil 1 ...]", rxRate)
+	}
+	if want := (float64(math.MaxUint32+1) - 4294967000 + 1024) / 60 / 1024; math.Abs(rxRate[3].(float64)-want) > 1e-9 {
+		t.Errorf("RX rate after wrap = %v, want %v", rxRate[3], want)
+	}
+	if want := []interface{}{nil, 0.0, 1024.0 / 60 / 1024, 1024.0 / 60 / 1024}; !reflect.DeepEqual(rate["TX"]["kilobytes"], want) {
+		t.Errorf("TX rate = %v, want %v", rate["TX"]["kilobytes"], want)
+	}
+	if want := []interface{}{nil, 10.0, 0.0, 0.0}; !reflect.DeepEqual(rate["RX"]["packets"], want) {
+		t.Errorf("RX packets rate = %v, want %v", rate["RX"]["packets"], want)
+	}
+	if _, ok := got["interfaces"]; ok {
+		t.Error("interfaces set without a per interface breakdown")
+	}
+}
+
+func TestCollectionFormatNetworkInterfaces(t *testing.T) {
+	c := testCollections(t,
+		`{"Network":{"Interfaces":{"eth0":{"RX":{"bytes":0,"packets":0},"TX":{"bytes":0,"packets":0}}}}}`,
+		`{"Network":{"Interfaces":{"eth0":{"RX":{"bytes":61440,"packets":60},"TX":{"bytes":0,"packets":0}},"wg0":{"RX":{"bytes":5,"packets":1},"TX":{"bytes":5,"packets":1}}}}}`,
+	)
+	got := CollectionFormat(c, "Network")
+
+	// Totals are summed from the interfaces when an agent only sends those.
+	if rx := got["RX"].(map[string][]float64); rx["packets"][1] != 0.061 {
+		t.Errorf("RX packets = %v, want the sum of the interfaces", rx["packets"])
+	}
+	interfaces := got["interfaces"].(map[string]interface{})
+	want := map[string]interface{}{"eth0": []interface{}{nil, 1.0}, "wg0": []interface{}{nil, nil}}
+	if got := interfaces["RX"].(map[string]interface{})["kilobytes"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("RX interfaces = %v, want %v", got, want)
+	}
+}
+
+func TestCollectionFormatIO(t *testing.T) {
+	c := testCollections(t,
+		`{"IO":{"read":{"count":10,"bytes":1048576,"time":5},"write":{"count":"20","bytes":"0","time":"1"}}}`,
+		`{"IO":{"read":{"count":70,"bytes":2097152,"time":65},"write":{"count":20,"bytes":0,"time":1}}}`,
+	)
+	got := CollectionFormat(c, "IO")
+	read := got["read"].(map[string][]float64)
+	if want := []float64{10, 70}; !reflect.DeepEqual(read["counts"], want) {
+		t.Errorf("read counts = %v, want %v", read["counts"], want)
+	}
+	if want := []float64{1, 2}; !reflect.DeepEqual(read["megabytes"], want) {
+		t.Errorf("read megabytes = %v, want %v", read["megabytes"], want)
+	}
+	rate := got["rate"].(map[string]map[string][]interface{})
+	for field, want := range map[string][]interface{}{
+		"counts":    {nil, 1.0},
+		"kilobytes": {nil, 1024.0 / 60},
+		"time_ms":   {nil, 1.0},
+	} {
+		if !reflect.DeepEqual(rate["read"][field], want) {
+			t.Errorf("read rate %s = %v, want %v", field, rate["read"][field], want)
+		}
+	}
+}
+
+func TestCollectionFormatBattery(t *testing.T) {
+	c := testCollections(t,
+		`{"Battery":{"percent":"80","power_plugged":false,"secsleft":3600}}`,
+		`{"Battery":{"percent":100,"power_plugged":true,"secsleft":-2}}`,
+	)
+	got := CollectionFormat(c, "Battery")
+	if want := []interface{}{"discharging", "full"}; !reflect.DeepEqual(got["state"], want) {
+		t.Errorf("state = %v, want %v", got["state"], want)
+	}
+	if want := []interface{}{false, true}; !reflect.DeepEqual(got["plugged"], want) {
+		t.Errorf("plugged = %v, want %v", got["plugged"], want)
+	}
+	// A negative secsleft means unknown or unlimited.
+	want := map[string]interface{}{"percent": 100.0, "state": "full", "plugged": true, "secsleft": nil}
+	if !reflect.DeepEqual(got["latest"], want) {
+		t.Errorf("latest = %v, want %v", got["latest"], want)
+	}
+}
+
+func TestCollectionFormatPing(t *testing.T) {
+	c := testCollections(t,
+		`{"Ping":{"8.8.8.8":{"latency":10,"loss":0},"1.1.1.1":{"latency":"20","loss":"0.5"}}}`,
+		`{"Ping":{"8.8.8.8":{"latency":null,"loss":1}}}`,
+		`{"Ping":{"8.8.8.8":{"latency":30,"loss":0}}}`,
+	)
+	got := CollectionFormat(c, "Ping")
+	want := map[string]interface{}{
+		"8.8.8.8": []interface{}{10.0, nil, 30.0},
+		"1.1.1.1": []interface{}{20.0, nil, nil},
+	}
+	if !reflect.DeepEqual(got["value"], want) {
+		t.Errorf("value = %v, want %v", got["value"], want)
+	}
+	stats := got["stats"].(map[string]interface{})
+	if want := map[string]interface{}{"min": 10.0, "avg": 20.0, "max": 30.0, "loss": 1.0 / 3}; !reflect.DeepEqual(stats["8.8.8.8"], want) {
+		t.Errorf("stats = %v, want %v", stats["8.8.8.8"], want)
+	}
+}
+
+func TestCollectionFormatDownsample(t *testing.T) {
+	c := testCollections(t,
+		`{"Thermal":{"cpu":40}}`,
+		`{"Thermal":{"cpu":60}}`,
+		`{"Thermal":{"cpu":50}}`,
+		`{"Thermal":{"cpu":70}}`,
+	)
+	// Points are one minute apart starting at 1700000040, a multiple of 120.
+	tests := []struct {
+		agg  Aggregation
+		want []interface{}
+	}{
+		{Aggregation{Step: 120, Func: "avg"}, []interface{}{50.0, 60.0}},
+		{Aggregation{Step: 120, Func: "max"}, []interface{}{60.0, 70.0}},
+		{Aggregation{Step: 120, Func: "min"}, []interface{}{40.0, 50.0}},
+		{Aggregation{Step: 120, Func: "last"}, []interface{}{60.0, 70.0}},
+		{Aggregation{Step: 3600, Func: "p95"}, []interface{}{70.0}},
+		{Aggregation{Func: "avg", MaxPoints: 2}, []interface{}{50.0, 60.0}},
+		{Aggregation{Func: "avg", MaxPoints: 10}, []interface{}{40.0, 60.0, 50.0, 70.0}},
+	}
+	for _, tt := range tests {
+		got := CollectionFormat(c, "Thermal", tt.agg)["value"].(map[string]interface{})["cpu"]
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%+v: cpu = %v, want %v", tt.agg, got, tt.want)
+		}
+	}
+}
+
+func TestGetCollectionStatus(t *testing.T) {
+	s := setupTestStore(t)
+	ctx := context.Background()
+	now := time.Now().Unix()
+
+	report := func(uuid string, ts int64, alive time.Duration) {
+		t.Helper()
+		err := s.SaveReport(ctx, uuid, NodeUpdate{
+			Point:    Point{Time: ts, Data: `{"Load":{"user":1}}`},
+			Info:     map[string]string{"Update Time": strconv.FormatInt(ts, 10)},
+			Address:  uuid + ".example",
+			AliveTTL: alive,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	report("online", now, time.Minute)
+	report("offline", now-3600, time.Millisecond)
+	report("hidden", now, time.Minute)
+	s.SetHidden(ctx, "hidden", true)
+	// Listed but without any point.
+	s.hset("hashes", "empty", "empty.example")
+	time.Sleep(5 * time.Millisecond)
+
+	status, err := GetCollectionStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string][]string{
+		"online":  {"online"},
+		"offline": {"offline"},
+		"info":    {"offline", "online"},
+	} {
+		m, _ := status.Get(key)
+		got := make([]string, 0, len(m))
+		for uuid := range m {
+			got = append(got, uuid)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s = %v, want %v", key, got, want)
+		}
+	}
+}
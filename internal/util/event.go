@@ -0,0 +1,213 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/elliotchance/orderedmap/v3"
+)
+
+// EventChannel is the store pub/sub channel node updates are published on,
+// with Redis so every instance behind a load balancer can push them to its clients.
+const EventChannel = "system_monitor:events"
+
+const (
+	EventCollection = "collection"
+	EventOnline     = "online"
+	EventOffline    = "offline"
+)
+
+type Event struct {
+	Type       string            `json:"type"`
+	UUID       string            `json:"uuid"`
+	Timestamp  int64             `json:"timestamp"`
+	Collection *CollectionData   `json:"collection,omitempty"`
+	Info       map[string]string `json:"info,omitempty"`
+	// Previous is the point before Collection, needed for the network and
+	// IO rates.
+	Previous          *CollectionData `json:"previous,omitempty"`
+	PreviousTimestamp int64           `json:"previous_timestamp,omitempty"`
+}
+
+// Series formats the collection point of an event the same way the /api
+// endpoints do, keyed by endpoint name, so charts can append the last value
+// of each series. Network and IO also hold the previous point when known.
+func (e Event) Series() map[string]interface{} {
+	result := map[string]interface{}{}
+	if e.Collection == nil || e.Collection.Empty() {
+		return result
+	}
+
+	point := orderedmap.NewOrderedMap[int64, CollectionData]()
+	point.Set(e.Timestamp, *e.Collection)
+	points := point
+	if e.Previous != nil && e.PreviousTimestamp < e.Timestamp {
+		points = orderedmap.NewOrderedMap[int64, CollectionData]()
+		points.Set(e.PreviousTimestamp, *e.Previous)
+		points.Set(e.Timestamp, *e.Collection)
+	}
+
+	for api, name := range map[string]string{
+		"cpu":     "Load",
+		"memory":  "Memory",
+		"disk":    "Disk",
+		"network": "Network",
+		"io":      "IO",
+		"thermal": "Thermal",
+		"battery": "Battery",
+		"ping":    "Ping",
+	} {
+		p := point
+		if name == "Network" || name == "IO" {
+			p = points
+		}
+		if v := CollectionFormat(p, name); len(v) > 0 {
+			result[api] = v
+		}
+	}
+	return result
+}
+
+func PublishEvent(e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	return DataStore.Publish(context.Background(), EventChannel, data)
+}
+
+// setNodeStatus records whether uuid is online in system_monitor:status and
+// publishes an online/offline event when that changes.
+func setNodeStatus(ctx context.Context, uuid string, online bool) error {
+	value := "0"
+	if online {
+		value = "1"
+	}
+
+	old, err := DataStore.GetStatus(ctx, uuid)
+	if err != nil {
+		return err
+	}
+	if old == value {
+		return nil
+	}
+	if err := DataStore.SetStatus(ctx, uuid, value); err != nil {
+		return err
+	}
+	// The first time a node is seen there is no transition to report.
+	if old == "" && online {
+		return nil
+	}
+
+	e := Event{Type: EventOffline, UUID: uuid, Timestamp: time.Now().Unix()}
+	if online {
+		e.Type = EventOnline
+	}
+	return PublishEvent(e)
+}
+
+// PublishStatusChanges checks every node and publishes online/offline transitions.
+// It runs from CronJob; nodes coming back online are also reported by SaveReport.
+// It stops once ctx is done and returns the errors of the nodes it could not
+// check.
+func PublishStatusChanges(ctx context.Context) error {
+	uuids, err := GetUUIDs(false)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for uuid := range uuids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info, _ := getInfo(ctx, uuid, false)
+		if err := setNodeStatus(ctx, uuid, isOnline(ctx, uuid, info)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", uuid, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// EventBroker shares a single store subscription between all local listeners.
+type EventBroker struct {
+	mu        sync.Mutex
+	listeners map[chan Event]struct{}
+	cancel    context.CancelFunc
+}
+
+var Events = &EventBroker{}
+
+// Subscribe returns a channel receiving every event until ctx is done.
+// Events are dropped for listeners that fall behind instead of blocking others.
+func (b *EventBroker) Subscribe(ctx context.Context) (<-chan Event, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.cancel == nil {
+		subCtx, cancel := context.WithCancel(context.Background())
+		if err := DataStore.Subscribe(subCtx, EventChannel, b.dispatch); err != nil {
+			cancel()
+			return nil, err
+		}
+		b.cancel = cancel
+		b.listeners = map[chan Event]struct{}{}
+	}
+
+	ch := make(chan Event, 16)
+	b.listeners[ch] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		// Close may have closed it already.
+		if _, ok := b.listeners[ch]; !ok {
+			return
+		}
+		delete(b.listeners, ch)
+		close(ch)
+		// Drop the store subscription when nobody is listening.
+		if len(b.listeners) == 0 && b.cancel != nil {
+			b.cancel()
+			b.cancel = nil
+		}
+	}()
+
+	return ch, nil
+}
+
+// Close closes the channel of every listener, which ends their streams, and
+// drops the store subscription. Later calls to Subscribe start a new one.
+func (b *EventBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.listeners {
+		delete(b.listeners, ch)
+		close(ch)
+	}
+	if b.cancel != nil {
+		b.cancel()
+		b.cancel = nil
+	}
+}
+
+func (b *EventBroker) dispatch(payload []byte) {
+	var e Event
+	if err := json.Unmarshal(payload, &e); err != nil {
+		fmt.Println("Error decoding event:", err)
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.listeners {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
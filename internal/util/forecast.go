@@ -0,0 +1,116 @@
+package util
+
+import (
+	"math"
+	"sort"
+
+	"github.com/elliotchance/orderedmap/v3"
+)
+
+// forecastMinSpan is the shortest history, in seconds, a forecast is made from.
+const forecastMinSpan = 3600
+
+// DiskForecast is the latest usage of a mountpoint, in MB, and when it fills
+// up if the used space keeps growing at the rate of a linear regression over
+// its history. Growth (MB per day) and DaysUntilFull are nil without enough
+// history, DaysUntilFull also when the usage is not growing.
+type DiskForecast struct {
+	Total         float64  `json:"total"`
+	Used          float64  `json:"used"`
+	Free          float64  `json:"free"`
+	Percent       float64  `json:"percent"`
+	Growth        *float64 `json:"growth"`
+	DaysUntilFull *float64 `json:"days_until_full"`
+}
+
+// ForecastDisk returns the forecast of every mountpoint of the latest point.
+func ForecastDisk(collections *orderedmap.OrderedMap[int64, CollectionData]) map[string]DiskForecast {
+	result := map[string]DiskForecast{}
+	if collections == nil || collections.Len() == 0 {
+		return result
+	}
+
+	type sample struct{ t, used float64 }
+	history := map[string][]sample{}
+	for score, collection := range collections.AllFromFront() {
+		for mountpoint, stat := range collection.Disk {
+			history[mountpoint] = append(history[mountpoint], sample{float64(score), stat.Used.Float()})
+		}
+	}
+
+	for mountpoint, stat := range collections.Back().Value.Disk {
+		f := DiskForecast{
+			Total:   stat.Total.Float(),
+			Used:    stat.Used.Float(),
+			Free:    stat.Free.Float(),
+			Percent: stat.Percent.Float(),
+		}
+
+		samples := history[mountpoint]
+		if len(samples) >= 2 && samples[len(samples)-1].t-samples[0].t >= forecastMinSpan {
+			xs := make([]float64, len(samples))
+			ys := make([]float64, len(samples))
+			for i, s := range samples {
+				xs[i], ys[i] = s.t, s.used
+			}
+			growth := round(linearSlope(xs, ys)*86400, 2)
+			f.Growth = &growth
+			if growth > 0 {
+				days := round(math.Max(f.Free, 0)/growth, 1)
+				f.DaysUntilFull = &days
+			}
+		}
+		result[mountpoint] = f
+	}
+	return result
+}
+
+// SoonestFull returns the mountpoint expected to fill up first.
+func SoonestFull(forecasts map[string]DiskForecast) (mountpoint string, days float64, ok bool) {
+	mountpoints := make([]string, 0, len(forecasts))
+	for m := range forecasts {
+		mountpoints = append(mountpoints, m)
+	}
+	sort.Strings(mountpoints)
+	for _, m := range mountpoints {
+		if d := forecasts[m].DaysUntilFull; d != nil && (!ok || *d < days) {
+			mountpoint, days, ok = m, *d, true
+		}
+	}
+	return mountpoint, days, ok
+}
+
+// GetDiskForecast forecasts the disks of uuid from its raw history.
+func GetDiskForecast(uuid string) (map[string]DiskForecast, error) {
+	collections, err := GetCollection(uuid, false)
+	if err != nil {
+		return nil, err
+	}
+	return ForecastDisk(collections), nil
+}
+
+// linearSlope returns the least squares slope of ys over xs.
+func linearSlope(xs, ys []float64) float64 {
+	var mx, my float64
+	for i := range xs {
+		mx += xs[i]
+		my += ys[i]
+	}
+	mx /= float64(len(xs))
+	my /= float64(len(ys))
+
+	var num, den float64
+	for i := range xs {
+		num += (xs[i] - mx) * (ys[i] - my)
+		den += (xs[i] - mx) * (xs[i] - mx)
+	}
+	if den == 0 {
+		return 0
+	}
+	return num / den
+}
+
+func round(v float64, digits int) float64 {
+	p := math.Pow(10, float64(digits))
+	return math.Round(v*p) / p
+}
@@ -1,9 +1,12 @@
 package util
 
 import (
+	"errors"
+	"io/fs"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/joho/godotenv"
 )
@@ -13,10 +16,37 @@ type Env struct {
 	Value string
 }
 
-// LoadEnv loads environment variables; this is a no-op fallback so the program compiles.
+var (
+	dotenvMu sync.Mutex
+	// dotenvKeys are the variables set from .env, as opposed to those of
+	// the real environment which always take precedence.
+	dotenvKeys = map[string]bool{}
+)
+
+// LoadEnv sets the variables of .env that are not in the environment. On
+// later calls the variables it set are updated, or unset when they were
+// removed from .env. A missing .env is no error.
 func LoadEnv() error {
-	// TODO: implement actual .env loading (e.g., using github.com/joho/godotenv) if needed.
-	_ = godotenv.Load()
+	values, err := godotenv.Read()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	dotenvMu.Lock()
+	defer dotenvMu.Unlock()
+	for key := range dotenvKeys {
+		if _, ok := values[key]; !ok {
+			os.Unsetenv(key)
+			delete(dotenvKeys, key)
+		}
+	}
+	for key, value := range values {
+		if _, exist := os.LookupEnv(key); exist && !dotenvKeys[key] {
+			continue
+		}
+		os.Setenv(key, value)
+		dotenvKeys[key] = true
+	}
 	return nil
 }
 
@@ -0,0 +1,68 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFilterSensitiveEnvs(t *testing.T) {
+	envs := []Env{
+		{"REDIS_PASSWORD", "hunter2"},
+		{"redis_password", "hunter2"},
+		{"ADMIN_PASSWORD_HASH", "$2a$10$abc"},
+		{"TELEGRAM_BOT_TOKEN", "123:abc"},
+		{"AWS_REGION", "eu-west-1"},
+		{"SESSION_SECRET", ""},
+		// Matching is by substring, so unrelated names are redacted too.
+		{"KEYBOARD", "us"},
+		{"REDIS_HOST", "127.0.0.1"},
+		{"PATH", "/usr/bin"},
+		{"LISTEN_PORT", "8888"},
+	}
+	want := []Env{
+		{"REDIS_PASSWORD", "<REDACTED>"},
+		{"redis_password", "<REDACTED>"},
+		{"ADMIN_PASSWORD_HASH", "<REDACTED>"},
+		{"TELEGRAM_BOT_TOKEN", "<REDACTED>"},
+		{"AWS_REGION", "<REDACTED>"},
+		{"SESSION_SECRET", ""},
+		{"KEYBOARD", "<REDACTED>"},
+		{"REDIS_HOST", "127.0.0.1"},
+		{"PATH", "/usr/bin"},
+		{"LISTEN_PORT", "8888"},
+	}
+
+	got := FilterSensitiveEnvs(envs)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FilterSensitiveEnvs() = %v, want %v", got, want)
+	}
+	if envs[0].Value != "hunter2" {
+		t.Error("FilterSensitiveEnvs modified its input")
+	}
+}
+
+func TestGetEnv(t *testing.T) {
+	t.Setenv("TEST_INT", "42")
+	t.Setenv("TEST_BAD_INT", "4x")
+	t.Setenv("TEST_BOOL", "t")
+	t.Setenv("TEST_EMPTY", "")
+
+	if got := GetEnv("TEST_EMPTY", "d"); got != "" {
+		t.Errorf("GetEnv of an empty variable = %q, want \"\"", got)
+	}
+	if got := GetEnv("TEST_UNSET", "d"); got != "d" {
+		t.Errorf("GetEnv of an unset variable = %q, want d", got)
+	}
+	if got := GetEnvInt("TEST_INT", 1); got != 42 {
+		t.Errorf("GetEnvInt = %d, want 42", got)
+	}
+	if got := GetEnvInt("TEST_BAD_INT", 1); got != 1 {
+		t.Errorf("GetEnvInt of an invalid value = %d, want the default", got)
+	}
+	if got := GetEnvBool("TEST_BOOL", false); !got {
+		t.Error("GetEnvBool(\"t\") = false")
+	}
+	if got := GetEnvBool("TEST_EMPTY", true); !got {
+		t.Error("GetEnvBool of an empty variable = false, want the default")
+	}
+}
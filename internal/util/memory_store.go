@@ -0,0 +1,342 @@
+package util
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store kept in process memory, for tests and single binary
+// deployments that can afford to lose their history on restart.
+// Like BoltStore it uses the Redis layout without the system_monitor: prefix.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	hashes map[string]map[string]string
+	series map[string][]Point
+	keys   map[string]memoryKey
+	events *localBus
+	done   chan struct{}
+	closed sync.Once
+}
+
+type memoryKey struct {
+	value   string
+	expires time.Time
+}
+
+func (k memoryKey) alive(now time.Time) bool {
+	return k.expires.IsZero() || now.Before(k.expires)
+}
+
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{
+		hashes: map[string]map[string]string{},
+		series: map[string][]Point{},
+		keys:   map[string]memoryKey{},
+		events: newLocalBus(),
+		done:   make(chan struct{}),
+	}
+	go s.sweep(time.Minute)
+	return s
+}
+
+// sweep drops expired keys, which are otherwise only dropped when read.
+func (s *MemoryStore) sweep(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for name, k := range s.keys {
+				if !k.alive(now) {
+					delete(s.keys, name)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *MemoryStore) hgetall(name string) map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make(map[string]string, len(s.hashes[name]))
+	for k, v := range s.hashes[name] {
+		result[k] = v
+	}
+	return result
+}
+
+func (s *MemoryStore) hget(name, field string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.hashes[name][field]
+}
+
+// hset must be called with s.mu held.
+func (s *MemoryStore) hset(name, field, value string) {
+	if s.hashes[name] == nil {
+		s.hashes[name] = map[string]string{}
+	}
+	s.hashes[name][field] = value
+}
+
+func (s *MemoryStore) set(name, field, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hset(name, field, value)
+	return nil
+}
+
+func (s *MemoryStore) del(name, field string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.hashes[name], field)
+	return nil
+}
+
+// appendPoints keeps the series ordered by time, then by insertion.
+// It must be called with s.mu held.
+func (s *MemoryStore) appendPoints(key string, points ...Point) {
+	series := s.series[key]
+	for _, p := range points {
+		i := sort.Search(len(series), func(i int) bool { return series[i].Time > p.Time })
+		series = append(series, Point{})
+		copy(series[i+1:], series[i:])
+		series[i] = p
+	}
+	s.series[key] = series
+}
+
+// key returns the value of an expiring key, dropping it once expired.
+// It must be called with s.mu held.
+func (s *MemoryStore) key(name string) (string, bool) {
+	k, ok := s.keys[name]
+	if ok && !k.alive(time.Now()) {
+		delete(s.keys, name)
+		return "", false
+	}
+	return k.value, ok
+}
+
+func (s *MemoryStore) ListNodes(ctx context.Context) (map[string]string, error) {
+	return s.hgetall("hashes"), nil
+}
+
+func (s *MemoryStore) GetInfo(ctx context.Context, uuid string) (map[string]string, error) {
+	return s.hgetall("info:" + uuid), nil
+}
+
+func (s *MemoryStore) IsAlive(ctx context.Context, uuid string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.key("alive:" + uuid)
+	return ok, nil
+}
+
+func (s *MemoryStore) SaveReport(ctx context.Context, uuid string, u NodeUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.appendPoints(SeriesCollection+":"+uuid, u.Point)
+	for k, v := range u.Info {
+		s.hset("info:"+uuid, k, v)
+	}
+	s.hset("hashes", uuid, u.Address)
+	k := memoryKey{value: strconv.FormatInt(u.Point.Time, 10)}
+	if u.AliveTTL > 0 {
+		k.expires = time.Now().Add(u.AliveTTL)
+	}
+	s.keys["alive:"+uuid] = k
+	return nil
+}
+
+func (s *MemoryStore) DeleteNode(ctx context.Context, uuid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.series, SeriesCollection+":"+uuid)
+	for _, tier := range RollupTiers {
+		delete(s.series, tier.Series()+":"+uuid)
+	}
+	delete(s.hashes, "info:"+uuid)
+	delete(s.hashes, "alert:"+uuid)
+	for _, key := range nodeHashes {
+		delete(s.hashes[strings.TrimPrefix(key, "system_monitor:")], uuid)
+	}
+	delete(s.keys, "alive:"+uuid)
+	return nil
+}
+
+func (s *MemoryStore) Purge(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range nodeHashes {
+		delete(s.hashes, strings.TrimPrefix(key, "system_monitor:"))
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetNames(ctx context.Context) (map[string]string, error) {
+	return s.hgetall("name"), nil
+}
+
+func (s *MemoryStore) SetName(ctx context.Context, uuid, name string) error {
+	if name == "" {
+		return s.del("name", uuid)
+	}
+	return s.set("name", uuid, name)
+}
+
+func (s *MemoryStore) GetHidden(ctx context.Context) (map[string]string, error) {
+	return s.hgetall("hide"), nil
+}
+
+func (s *MemoryStore) SetHidden(ctx context.Context, uuid string, hidden bool) error {
+	if hidden {
+		return s.set("hide", uuid, "1")
+	}
+	return s.del("hide", uuid)
+}
+
+func (s *MemoryStore) GetStatus(ctx context.Context, uuid string) (string, error) {
+	return s.hget("status", uuid), nil
+}
+
+func (s *MemoryStore) SetStatus(ctx context.Context, uuid, status string) error {
+	return s.set("status", uuid, status)
+}
+
+func (s *MemoryStore) GetToken(ctx context.Context, uuid string) (string, error) {
+	return s.hget("token", uuid), nil
+}
+
+func (s *MemoryStore) SetToken(ctx context.Context, uuid, token string) error {
+	return s.set("token", uuid, token)
+}
+
+func (s *MemoryStore) GetAlertStates(ctx context.Context, uuid string) (map[string]string, error) {
+	return s.hgetall("alert:" + uuid), nil
+}
+
+func (s *MemoryStore) SetAlertState(ctx context.Context, uuid, rule, state string) error {
+	return s.set("alert:"+uuid, rule, state)
+}
+
+func (s *MemoryStore) DeleteAlertState(ctx context.Context, uuid, rule string) error {
+	return s.del("alert:"+uuid, rule)
+}
+
+func (s *MemoryStore) AppendPoints(ctx context.Context, series, uuid string, points ...Point) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.appendPoints(series+":"+uuid, points...)
+	return nil
+}
+
+func (s *MemoryStore) RangePoints(ctx context.Context, series, uuid string, start, end int64) ([]Point, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	points := s.series[series+":"+uuid]
+	from := sort.Search(len(points), func(i int) bool { return points[i].Time >= start })
+	to := sort.Search(len(points), func(i int) bool { return points[i].Time > end })
+	if from >= to {
+		return []Point{}, nil
+	}
+	return append([]Point{}, points[from:to]...), nil
+}
+
+func (s *MemoryStore) LastPoint(ctx context.Context, series, uuid string, before int64) (*Point, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	points := s.series[series+":"+uuid]
+	i := len(points)
+	if before > 0 {
+		i = sort.Search(len(points), func(i int) bool { return points[i].Time >= before })
+	}
+	if i == 0 {
+		return nil, nil
+	}
+	p := points[i-1]
+	return &p, nil
+}
+
+func (s *MemoryStore) CountPoints(ctx context.Context, series, uuid string) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int64(len(s.series[series+":"+uuid])), nil
+}
+
+func (s *MemoryStore) Retain(ctx context.Context, series, uuid string, cutoff int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := series + ":" + uuid
+	points := s.series[key]
+	i := sort.Search(len(points), func(i int) bool { return points[i].Time > cutoff })
+	if i > 0 {
+		s.series[key] = append([]Point{}, points[i:]...)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.key(key); ok {
+		return false, nil
+	}
+	k := memoryKey{value: "1"}
+	if ttl > 0 {
+		k.expires = time.Now().Add(ttl)
+	}
+	s.keys[key] = k
+	return true, nil
+}
+
+func (s *MemoryStore) GetCounter(ctx context.Context, key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, _ := s.key(key)
+	n, _ := strconv.ParseInt(v, 10, 64)
+	return n, nil
+}
+
+func (s *MemoryStore) IncrCounter(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, _ := s.key(key)
+	n, _ := strconv.ParseInt(v, 10, 64)
+	n++
+	k := memoryKey{value: strconv.FormatInt(n, 10)}
+	if ttl > 0 {
+		k.expires = time.Now().Add(ttl)
+	}
+	s.keys[key] = k
+	return n, nil
+}
+
+func (s *MemoryStore) ResetCounter(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, key)
+	return nil
+}
+
+func (s *MemoryStore) Publish(ctx context.Context, channel string, payload []byte) error {
+	s.events.publish(channel, payload)
+	return nil
+}
+
+func (s *MemoryStore) Subscribe(ctx context.Context, channel string, handler func(payload []byte)) error {
+	s.events.subscribe(ctx, channel, handler)
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	s.closed.Do(func() { close(s.done) })
+	return nil
+}
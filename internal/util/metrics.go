@@ -0,0 +1,185 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// megabyte is the unit agents use for memory and disk sizes.
+const megabyte = 1048576
+
+type metricFamily struct {
+	name    string
+	help    string
+	typ     string
+	samples []string
+}
+
+// metricSet collects samples in the Prometheus text exposition format.
+type metricSet struct {
+	families map[string]*metricFamily
+	order    []string
+}
+
+func newMetricSet() *metricSet {
+	return &metricSet{families: map[string]*metricFamily{}}
+}
+
+func (m *metricSet) add(name, typ, help string, value float64, labels ...string) {
+	f, ok := m.families[name]
+	if !ok {
+		f = &metricFamily{name: name, help: help, typ: typ}
+		m.families[name] = f
+		m.order = append(m.order, name)
+	}
+
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], escapeLabel(labels[i+1])))
+	}
+	sample := name
+	if len(pairs) > 0 {
+		sample += "{" + strings.Join(pairs, ",") + "}"
+	}
+	f.samples = append(f.samples, sample+" "+strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func (m *metricSet) write(w io.Writer) error {
+	for _, name := range m.order {
+		f := m.families[name]
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s\n", f.name, f.help, f.name, f.typ, strings.Join(f.samples, "\n")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WritePrometheusMetrics exports the latest collection of every visible node
+// as gauges, followed by the collection cache and cron job statistics.
+func WritePrometheusMetrics(w io.Writer) error {
+	uuids, err := GetUUIDs(false)
+	if err != nil {
+		return err
+	}
+	names, _ := GetDisplayName(false)
+	hidden, _ := GetHiddenNodes(false)
+
+	keys := make([]string, 0, len(uuids))
+	for uuid := range uuids {
+		if _, ok := hidden[uuid]; !ok {
+			keys = append(keys, uuid)
+		}
+	}
+	sort.Strings(keys)
+
+	m := newMetricSet()
+	for _, uuid := range keys {
+		name := names[uuid]
+		if name == "" {
+			name = uuids[uuid]
+		}
+		node := []string{"uuid", uuid, "name", name}
+		with := func(labels ...string) []string {
+			return append(append([]string{}, node...), labels...)
+		}
+
+		info, _ := GetInfo(uuid, false)
+		up := 0.0
+		if IsOnline(uuid, info) {
+			up = 1
+		}
+		m.add("server_monitor_up", "gauge", "Whether the node is online (1) or offline (0).", up, node...)
+		if t, err := toFloat64(info["Update Time"]); err == nil {
+			m.add("server_monitor_last_update_timestamp_seconds", "gauge", "Unix time of the last info update.", t, node...)
+		}
+
+		latest, err := GetCollectionLatest(uuid)
+		if err != nil || latest.Empty() {
+			continue
+		}
+
+		if mem := latest.Memory; mem != nil {
+			for _, kind := range []string{"Mem", "Swap"} {
+				stat := mem.Mem
+				if kind == "Swap" {
+					stat = mem.Swap
+				}
+				m.add("server_monitor_memory_used_bytes", "gauge", "Used memory in bytes.", stat.Used.Float()*megabyte, with("type", kind)...)
+				m.add("server_monitor_memory_total_bytes", "gauge", "Total memory in bytes.", stat.Total.Float()*megabyte, with("type", kind)...)
+			}
+		}
+
+		for _, mountpoint := range sortedKeys(latest.Disk) {
+			stat := latest.Disk[mountpoint]
+			m.add("server_monitor_disk_used_bytes", "gauge", "Used disk space in bytes.", stat.Used.Float()*megabyte, with("mountpoint", mountpoint)...)
+			m.add("server_monitor_disk_total_bytes", "gauge", "Total disk space in bytes.", stat.Total.Float()*megabyte, with("mountpoint", mountpoint)...)
+			m.add("server_monitor_disk_used_percent", "gauge", "Used disk space in percent.", stat.Percent.Float(), with("mountpoint", mountpoint)...)
+		}
+
+		for _, metric := range sortedKeys(latest.Load) {
+			m.add("server_monitor_load", "gauge", "CPU load metrics reported by the agent.", latest.Load[metric].Float(), with("metric", metric)...)
+		}
+
+		for _, sensor := range sortedKeys(latest.Thermal) {
+			m.add("server_monitor_thermal_celsius", "gauge", "Thermal sensor temperature in Celsius.", latest.Thermal[sensor].Float(), with("sensor", sensor)...)
+		}
+
+		if b := latest.Battery; b != nil {
+			m.add("server_monitor_battery_percent", "gauge", "Battery charge in percent.", b.Percent.Float(), node...)
+			if b.PowerPlugged != nil {
+				v := 0.0
+				if *b.PowerPlugged {
+					v = 1
+				}
+				m.add("server_monitor_battery_power_plugged", "gauge", "Whether the node runs on external power.", v, node...)
+			}
+		}
+
+		if n := latest.Network; n != nil {
+			m.add("server_monitor_network_receive_bytes_total", "counter", "Bytes received since boot.", n.RX.Bytes.Float(), node...)
+			m.add("server_monitor_network_transmit_bytes_total", "counter", "Bytes transmitted since boot.", n.TX.Bytes.Float(), node...)
+			m.add("server_monitor_network_receive_packets_total", "counter", "Packets received since boot.", n.RX.Packets.Float(), node...)
+			m.add("server_monitor_network_transmit_packets_total", "counter", "Packets transmitted since boot.", n.TX.Packets.Float(), node...)
+		}
+	}
+
+	var stats CacheStats
+	if CollectionCache != nil {
+		stats = CollectionCache.Stats()
+	}
+	m.add("server_monitor_collection_cache_hits_total", "counter", "Collection cache hits.", float64(stats.Hits))
+	m.add("server_monitor_collection_cache_misses_total", "counter", "Collection cache misses, including expired entries.", float64(stats.Misses))
+	m.add("server_monitor_collection_cache_evictions_total", "counter", "Nodes evicted from the collection cache to stay within its size.", float64(stats.Evictions))
+	m.add("server_monitor_collection_cache_entries", "gauge", "Nodes held in the collection cache.", float64(stats.Entries))
+	m.add("server_monitor_collection_cache_points", "gauge", "Points held in the collection cache.", float64(stats.Points))
+
+	cron := GetCronStats()
+	m.add("server_monitor_cron_runs_total", "counter", "Completed cron job runs.", float64(cron.Runs))
+	m.add("server_monitor_cron_last_run_duration_seconds", "gauge", "Duration of the last cron job run, jitter included.", cron.LastRunDuration.Seconds())
+	for _, name := range sortedKeys(cron.Tasks) {
+		for _, result := range sortedKeys(cron.Tasks[name]) {
+			m.add("server_monitor_cron_tasks_total", "counter", "Finished cron tasks by result: ok, error or timeout.", float64(cron.Tasks[name][result]), "task", name, "result", result)
+		}
+	}
+	for _, name := range sortedKeys(cron.TaskDuration) {
+		m.add("server_monitor_cron_task_duration_seconds_total", "counter", "Time spent running cron tasks.", cron.TaskDuration[name].Seconds(), "task", name)
+	}
+
+	return m.write(w)
+}
@@ -0,0 +1,117 @@
+package util
+
+import (
+	"context"
+	"time"
+)
+
+// invalidateNodeCache drops every local cache entry related to uuid.
+func invalidateNodeCache(uuid string) {
+	if MapStringCache != nil {
+		MapStringCache.Delete("system_monitor:hashes")
+		MapStringCache.Delete("system_monitor:name")
+		MapStringCache.Delete("system_monitor:hide")
+		MapStringCache.Delete("system_monitor:info:" + uuid)
+	}
+	if CollectionCache != nil {
+		CollectionCache.Delete("system_monitor:collection:" + uuid)
+	}
+	DeleteDiskCache("system_monitor:collection:" + uuid)
+}
+
+func GetHiddenNodes(refresh bool) (map[string]string, error) {
+	if !refresh && MapStringCache != nil && MapStringCache.Get("system_monitor:hide") != nil {
+		return MapStringCache.Get("system_monitor:hide").Value(), nil
+	}
+
+	data, err := DataStore.GetHidden(context.Background())
+	if err != nil {
+		return map[string]string{}, err
+	}
+
+	if MapStringCache != nil {
+		MapStringCache.Set(
+			"system_monitor:hide",
+			data,
+			time.Duration(Conf().Cache.LocalTime)*time.Second,
+		)
+	}
+	return data, nil
+}
+
+// IsNodeHidden reports whether uuid is hidden from the public dashboard.
+func IsNodeHidden(uuid string) bool {
+	hidden, _ := GetHiddenNodes(false)
+	_, ok := hidden[uuid]
+	return ok
+}
+
+// SetDisplayName renames a node. An empty name restores the default (its address).
+func SetDisplayName(uuid, name string) error {
+	err := DataStore.SetName(context.Background(), uuid, name)
+	invalidateNodeCache(uuid)
+	return err
+}
+
+// SetNodeHidden hides or shows a node on the public dashboard.
+func SetNodeHidden(uuid string, hidden bool) error {
+	err := DataStore.SetHidden(context.Background(), uuid, hidden)
+	invalidateNodeCache(uuid)
+	return err
+}
+
+// DeleteNode removes every series, key and field belonging to uuid.
+func DeleteNode(uuid string) error {
+	if err := DataStore.DeleteNode(context.Background(), uuid); err != nil {
+		return err
+	}
+	invalidateNodeCache(uuid)
+	return nil
+}
+
+// PurgeNodes deletes all nodes and their data.
+func PurgeNodes() (int, error) {
+	uuids, err := GetUUIDs(true)
+	if err != nil {
+		return 0, err
+	}
+	for uuid := range uuids {
+		if err := DeleteNode(uuid); err != nil {
+			return 0, err
+		}
+	}
+	if err := DataStore.Purge(context.Background()); err != nil {
+		return 0, err
+	}
+	return len(uuids), nil
+}
+
+// RemoveInvalidNodes deletes nodes that have no info or no collection data left,
+// e.g. because every point expired through DATA_RETENTION_DAYS.
+func RemoveInvalidNodes() (int, error) {
+	ctx := context.Background()
+	uuids, err := GetUUIDs(true)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for uuid := range uuids {
+		n, err := DataStore.CountPoints(ctx, SeriesCollection, uuid)
+		if err != nil {
+			return removed, err
+		}
+		info, err := DataStore.GetInfo(ctx, uuid)
+		if err != nil {
+			return removed, err
+		}
+		if n > 0 && len(info) > 0 {
+			continue
+		}
+		if err := DeleteNode(uuid); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
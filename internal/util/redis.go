@@ -63,18 +63,28 @@ func RedisGet(ctx context.Context, r *redis.Client, key string) (string, error)
 }
 
 // RedisSubscribe subscribes to a channel and calls handler for each message.
-// The subscription runs in a goroutine; caller is responsible for context cancellation.
+// The subscription runs in a goroutine and is closed once ctx is cancelled.
 func RedisSubscribe(ctx context.Context, r *redis.Client, channel string, handler func(msg *redis.Message)) error {
 	sub := r.Subscribe(ctx, channel)
 	// Confirm subscription
 	if _, err := sub.Receive(ctx); err != nil {
+		_ = sub.Close()
 		return fmt.Errorf("subscribe receive: %w", err)
 	}
 	ch := sub.Channel()
 
 	go func() {
-		for msg := range ch {
-			handler(msg)
+		defer sub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-ch:
+				if !ok {
+					return
+				}
+				handler(msg)
+			}
 		}
 	}()
 
@@ -99,17 +109,17 @@ func GetRedisClient() *redis.Client {
 // REDIS_TLS_KEY_FILE - Path to client key (optional)
 // REDIS_TLS_CA_FILE - Path to CA certificate (optional)
 func buildTLSConfig() (*tls.Config, error) {
-	if !GetEnvBool("REDIS_TLS_ENABLED", false) {
+	if !Conf().Redis.TLSEnabled {
 		return nil, nil
 	}
 
 	tlsConfig := &tls.Config{
-		InsecureSkipVerify: GetEnvBool("REDIS_TLS_INSECURE", false),
+		InsecureSkipVerify: Conf().Redis.TLSInsecure,
 	}
 
 	// Load client certificate and key if provided
-	certFile := GetEnv("REDIS_TLS_CERT_FILE", "")
-	keyFile := GetEnv("REDIS_TLS_KEY_FILE", "")
+	certFile := Conf().Redis.TLSCertFile
+	keyFile := Conf().Redis.TLSKeyFile
 	if certFile != "" && keyFile != "" {
 		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
 		if err != nil {
@@ -119,7 +129,7 @@ func buildTLSConfig() (*tls.Config, error) {
 	}
 
 	// Load CA certificate if provided
-	caFile := GetEnv("REDIS_TLS_CA_FILE", "")
+	caFile := Conf().Redis.TLSCAFile
 	if caFile != "" {
 		caCert, err := os.ReadFile(caFile)
 		if err != nil {
@@ -142,11 +152,11 @@ func buildTLSConfig() (*tls.Config, error) {
 // TLS options (see buildTLSConfig)
 func SetupRedis() error {
 	addr := fmt.Sprintf("%s:%s",
-		GetEnv("REDIS_HOST", "127.0.0.1"),
-		GetEnv("REDIS_PORT", "6379"),
+		Conf().Redis.Host,
+		Conf().Redis.Port,
 	)
-	password := GetEnv("REDIS_PASSWORD", "")
-	db := GetEnvInt("REDIS_DB", 0)
+	password := Conf().Redis.Password
+	db := Conf().Redis.DB
 
 	// Build TLS config if enabled
 	tlsConfig, err := buildTLSConfig()
@@ -285,20 +295,20 @@ func RedisZRangeByScore(ctx context.Context, r *redis.Client, key string, opt *r
 }
 
 // RedisZRangeByScoreWithScores returns members with scores in a score range.
-func RedisZRangeByScoreWithScores(ctx context.Context, r *redis.Client, key string, opt *redis.ZRangeBy, load_cache bool) ([]redis.Z, error) {
-	if load_cache {
-		vals, err := GetDiskCacheRedisZ(key)
-		if err == nil {
-			return vals, nil
-		}
-	}
-
+func RedisZRangeByScoreWithScores(ctx context.Context, r *redis.Client, key string, opt *redis.ZRangeBy) ([]redis.Z, error) {
 	vals, err := r.ZRangeByScoreWithScores(ctx, key, opt).Result()
 	if err != nil {
 		return nil, fmt.Errorf("redis zrangebyscore with scores %q: %w", key, err)
 	}
+	return vals, nil
+}
 
-	SetDiskCacheRedisZ(key, vals)
+// RedisZRevRangeByScoreWithScores returns members with scores in a score range, highest first.
+func RedisZRevRangeByScoreWithScores(ctx context.Context, r *redis.Client, key string, opt *redis.ZRangeBy) ([]redis.Z, error) {
+	vals, err := r.ZRevRangeByScoreWithScores(ctx, key, opt).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis zrevrangebyscore with scores %q: %w", key, err)
+	}
 	return vals, nil
 }
 
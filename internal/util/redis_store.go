@@ -0,0 +1,276 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore keeps the Redis layout shared with the standalone agents:
+// a ZSET per series (system_monitor:collection:<uuid>, ...), an info hash and
+// alive key per node, and hashes holding one field per node.
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{client: client}
+}
+
+// nodeHashes lists the shared hashes that hold one field per node.
+var nodeHashes = []string{
+	"system_monitor:hashes",
+	"system_monitor:name",
+	"system_monitor:hide",
+	"system_monitor:token",
+	"system_monitor:status",
+}
+
+func seriesKey(series, uuid string) string {
+	return "system_monitor:" + series + ":" + uuid
+}
+
+// nodeKeys returns every per-node key written by agents and the report API.
+func nodeKeys(uuid string) []string {
+	keys := []string{
+		seriesKey(SeriesCollection, uuid),
+		"system_monitor:info:" + uuid,
+		"system_monitor:alive:" + uuid,
+		"system_monitor:alert:" + uuid,
+	}
+	for _, tier := range RollupTiers {
+		keys = append(keys, seriesKey(tier.Series(), uuid))
+	}
+	return keys
+}
+
+func (s *RedisStore) hget(ctx context.Context, key, field string) (string, error) {
+	v, err := s.client.HGet(ctx, key, field).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("redis hget %q %q: %w", key, field, err)
+	}
+	return v, nil
+}
+
+func (s *RedisStore) hset(ctx context.Context, key, field, value string) error {
+	return RedisHSet(ctx, s.client, key, map[string]interface{}{field: value})
+}
+
+func (s *RedisStore) hdel(ctx context.Context, key, field string) error {
+	_, err := RedisHDel(ctx, s.client, key, field)
+	return err
+}
+
+func (s *RedisStore) ListNodes(ctx context.Context) (map[string]string, error) {
+	return RedisHGetAll(ctx, s.client, "system_monitor:hashes")
+}
+
+func (s *RedisStore) GetInfo(ctx context.Context, uuid string) (map[string]string, error) {
+	return RedisHGetAll(ctx, s.client, "system_monitor:info:"+uuid)
+}
+
+func (s *RedisStore) IsAlive(ctx context.Context, uuid string) (bool, error) {
+	return RedisExists(ctx, s.client, "system_monitor:alive:"+uuid)
+}
+
+func (s *RedisStore) SaveReport(ctx context.Context, uuid string, u NodeUpdate) error {
+	info := make(map[string]interface{}, len(u.Info))
+	for k, v := range u.Info {
+		info[k] = v
+	}
+
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, seriesKey(SeriesCollection, uuid), redis.Z{Score: float64(u.Point.Time), Member: u.Point.Data})
+		if len(info) > 0 {
+			pipe.HSet(ctx, "system_monitor:info:"+uuid, info)
+		}
+		pipe.HSet(ctx, "system_monitor:hashes", uuid, u.Address)
+		pipe.Set(ctx, "system_monitor:alive:"+uuid, u.Point.Time, u.AliveTTL)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("redis save report %q: %w", uuid, err)
+	}
+	return nil
+}
+
+func (s *RedisStore) DeleteNode(ctx context.Context, uuid string) error {
+	if _, err := RedisDel(ctx, s.client, nodeKeys(uuid)...); err != nil {
+		return err
+	}
+	for _, key := range nodeHashes {
+		if _, err := RedisHDel(ctx, s.client, key, uuid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RedisStore) Purge(ctx context.Context) error {
+	_, err := RedisDel(ctx, s.client, nodeHashes...)
+	return err
+}
+
+func (s *RedisStore) GetNames(ctx context.Context) (map[string]string, error) {
+	return RedisHGetAll(ctx, s.client, "system_monitor:name")
+}
+
+func (s *RedisStore) SetName(ctx context.Context, uuid, name string) error {
+	if name == "" {
+		return s.hdel(ctx, "system_monitor:name", uuid)
+	}
+	return s.hset(ctx, "system_monitor:name", uuid, name)
+}
+
+func (s *RedisStore) GetHidden(ctx context.Context) (map[string]string, error) {
+	return RedisHGetAll(ctx, s.client, "system_monitor:hide")
+}
+
+func (s *RedisStore) SetHidden(ctx context.Context, uuid string, hidden bool) error {
+	if hidden {
+		return s.hset(ctx, "system_monitor:hide", uuid, "1")
+	}
+	return s.hdel(ctx, "system_monitor:hide", uuid)
+}
+
+func (s *RedisStore) GetStatus(ctx context.Context, uuid string) (string, error) {
+	return s.hget(ctx, "system_monitor:status", uuid)
+}
+
+func (s *RedisStore) SetStatus(ctx context.Context, uuid, status string) error {
+	return s.hset(ctx, "system_monitor:status", uuid, status)
+}
+
+func (s *RedisStore) GetToken(ctx context.Context, uuid string) (string, error) {
+	return s.hget(ctx, "system_monitor:token", uuid)
+}
+
+func (s *RedisStore) SetToken(ctx context.Context, uuid, token string) error {
+	return s.hset(ctx, "system_monitor:token", uuid, token)
+}
+
+func (s *RedisStore) GetAlertStates(ctx context.Context, uuid string) (map[string]string, error) {
+	return RedisHGetAll(ctx, s.client, "system_monitor:alert:"+uuid)
+}
+
+func (s *RedisStore) SetAlertState(ctx context.Context, uuid, rule, state string) error {
+	return s.hset(ctx, "system_monitor:alert:"+uuid, rule, state)
+}
+
+func (s *RedisStore) DeleteAlertState(ctx context.Context, uuid, rule string) error {
+	return s.hdel(ctx, "system_monitor:alert:"+uuid, rule)
+}
+
+func (s *RedisStore) AppendPoints(ctx context.Context, series, uuid string, points ...Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+	members := make([]redis.Z, len(points))
+	for i, p := range points {
+		members[i] = redis.Z{Score: float64(p.Time), Member: p.Data}
+	}
+	_, err := RedisZAdd(ctx, s.client, seriesKey(series, uuid), members...)
+	return err
+}
+
+func (s *RedisStore) RangePoints(ctx context.Context, series, uuid string, start, end int64) ([]Point, error) {
+	data, err := RedisZRangeByScoreWithScores(ctx, s.client, seriesKey(series, uuid), &redis.ZRangeBy{
+		Min: strconv.FormatInt(start, 10),
+		Max: strconv.FormatInt(end, 10),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return toPoints(data), nil
+}
+
+func (s *RedisStore) LastPoint(ctx context.Context, series, uuid string, before int64) (*Point, error) {
+	max := "+inf"
+	if before > 0 {
+		max = "(" + strconv.FormatInt(before, 10)
+	}
+	data, err := RedisZRevRangeByScoreWithScores(ctx, s.client, seriesKey(series, uuid), &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   max,
+		Count: 1,
+	})
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	return &toPoints(data)[0], nil
+}
+
+func (s *RedisStore) CountPoints(ctx context.Context, series, uuid string) (int64, error) {
+	n, err := s.client.ZCard(ctx, seriesKey(series, uuid)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("redis zcard %q: %w", uuid, err)
+	}
+	return n, nil
+}
+
+func (s *RedisStore) Retain(ctx context.Context, series, uuid string, cutoff int64) error {
+	_, err := RedisZRemRangeByScore(ctx, s.client, seriesKey(series, uuid), "-inf", strconv.FormatInt(cutoff, 10))
+	return err
+}
+
+func (s *RedisStore) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	ok, err := s.client.SetNX(ctx, "system_monitor:"+key, 1, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis setnx %q: %w", key, err)
+	}
+	return ok, nil
+}
+
+func (s *RedisStore) GetCounter(ctx context.Context, key string) (int64, error) {
+	n, err := s.client.Get(ctx, "system_monitor:"+key).Int64()
+	if err != nil && err != redis.Nil {
+		return 0, fmt.Errorf("redis get %q: %w", key, err)
+	}
+	return n, nil
+}
+
+func (s *RedisStore) IncrCounter(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	n, err := RedisIncr(ctx, s.client, "system_monitor:"+key)
+	if err != nil {
+		return 0, err
+	}
+	_, err = RedisExpire(ctx, s.client, "system_monitor:"+key, ttl)
+	return n, err
+}
+
+func (s *RedisStore) ResetCounter(ctx context.Context, key string) error {
+	_, err := RedisDel(ctx, s.client, "system_monitor:"+key)
+	return err
+}
+
+func (s *RedisStore) Publish(ctx context.Context, channel string, payload []byte) error {
+	if err := s.client.Publish(ctx, channel, payload).Err(); err != nil {
+		return fmt.Errorf("redis publish %q: %w", channel, err)
+	}
+	return nil
+}
+
+func (s *RedisStore) Subscribe(ctx context.Context, channel string, handler func(payload []byte)) error {
+	return RedisSubscribe(ctx, s.client, channel, func(msg *redis.Message) {
+		handler([]byte(msg.Payload))
+	})
+}
+
+func (s *RedisStore) Close() error {
+	return RedisClose(s.client)
+}
+
+func toPoints(data []redis.Z) []Point {
+	points := make([]Point, 0, len(data))
+	for _, item := range data {
+		member, _ := item.Member.(string)
+		points = append(points, Point{Time: int64(item.Score), Data: member})
+	}
+	return points
+}
@@ -0,0 +1,168 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var uuidPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// Report is the document posted by agents to /api/report/:uuid.
+// Collection and Info follow the structures described in the README.
+type Report struct {
+	Timestamp  int64
+	Collection CollectionData
+	Info       map[string]string
+}
+
+// ValidationError lists every problem found in a report payload.
+type ValidationError struct {
+	Fields []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid report: " + strings.Join(e.Fields, "; ")
+}
+
+func (e *ValidationError) add(format string, a ...any) {
+	e.Fields = append(e.Fields, fmt.Sprintf(format, a...))
+}
+
+// ValidUUID reports whether uuid is safe to be used inside a store key.
+func ValidUUID(uuid string) bool {
+	return uuidPattern.MatchString(uuid)
+}
+
+// MaxReportSize limits the accepted body of a single agent report.
+const MaxReportSize = 1 << 20
+
+// ParseReport decodes and validates a report body.
+// Accepted top level keys are "Collection", "Info" and an optional "Timestamp"
+// (unix seconds); when no timestamp is given "Update Time" from Info is used,
+// falling back to the current time.
+func ParseReport(body []byte) (*Report, error) {
+	var raw struct {
+		Timestamp  json.Number            `json:"Timestamp"`
+		Collection json.RawMessage        `json:"Collection"`
+		Info       map[string]interface{} `json:"Info"`
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal report: %w", err)
+	}
+
+	verr := &ValidationError{}
+	collection, err := DecodeCollection(raw.Collection)
+	if e, ok := err.(*ValidationError); ok {
+		verr.Fields = append(verr.Fields, e.Fields...)
+	}
+	if raw.Info == nil {
+		verr.add("Info: required")
+	}
+
+	info := map[string]string{}
+	for k, v := range raw.Info {
+		switch val := v.(type) {
+		case string:
+			info[k] = val
+		case json.Number:
+			info[k] = val.String()
+		case bool:
+			info[k] = strconv.FormatBool(val)
+		case nil:
+			info[k] = ""
+		default:
+			verr.add("Info.%s: expected string, got %T", k, v)
+		}
+	}
+
+	if len(verr.Fields) > 0 {
+		sort.Strings(verr.Fields)
+		return nil, verr
+	}
+
+	now := time.Now().Unix()
+	ts := now
+	if raw.Timestamp != "" {
+		f, err := raw.Timestamp.Float64()
+		if err != nil {
+			return nil, &ValidationError{Fields: []string{"Timestamp: not a number"}}
+		}
+		ts = int64(f)
+	} else if t, err := toFloat64(info["Update Time"]); err == nil && t > 0 {
+		ts = int64(t)
+	}
+	if ts > now+60 {
+		return nil, &ValidationError{Fields: []string{"Timestamp: in the future"}}
+	}
+	if _, ok := info["Update Time"]; !ok {
+		info["Update Time"] = strconv.FormatInt(ts, 10)
+	}
+
+	return &Report{Timestamp: ts, Collection: collection, Info: info}, nil
+}
+
+// SaveReport writes a validated report; with Redis this is the same layout as
+// the standalone agents: collection ZSET, info hash, node hash and alive key.
+func SaveReport(uuid string, report *Report) error {
+	data, err := json.Marshal(report.Collection)
+	if err != nil {
+		return fmt.Errorf("failed to marshal collection: %w", err)
+	}
+
+	address := report.Info["IPV4"]
+	if address == "" {
+		address = uuid
+	}
+
+	ctx := context.Background()
+	// The point before this one lets live charts compute rates.
+	previous, _ := DataStore.LastPoint(ctx, SeriesCollection, uuid, report.Timestamp)
+
+	err = DataStore.SaveReport(ctx, uuid, NodeUpdate{
+		Point:    Point{Time: report.Timestamp, Data: string(data)},
+		Info:     report.Info,
+		Address:  address,
+		AliveTTL: time.Duration(Conf().Data.OfflineThreshold) * time.Second,
+	})
+	if err != nil {
+		return err
+	}
+
+	if MapStringCache != nil {
+		MapStringCache.Delete("system_monitor:info:" + uuid)
+		MapStringCache.Delete("system_monitor:hashes")
+	}
+
+	// Live updates are best effort, the report itself is already stored.
+	if err := setNodeStatus(ctx, uuid, true); err != nil {
+		fmt.Println("Error publishing status event:", uuid, err)
+	}
+	e := Event{
+		Type:       EventCollection,
+		UUID:       uuid,
+		Timestamp:  report.Timestamp,
+		Collection: &report.Collection,
+		Info:       report.Info,
+	}
+	if previous != nil {
+		if d, err := UnmarshalJSONData(previous.Data); err == nil {
+			e.Previous = d
+			e.PreviousTimestamp = previous.Time
+		}
+	}
+	err = PublishEvent(e)
+	if err != nil {
+		fmt.Println("Error publishing collection event:", uuid, err)
+	}
+	return nil
+}
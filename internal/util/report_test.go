@@ -0,0 +1,39 @@
+package util
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseReportMemory(t *testing.T) {
+	tests := map[string]struct {
+		memory string
+		fields []string
+	}{
+		"with swap":    {`{"Mem":{"total":"1000","used":"100","percent":10},"Swap":{"total":"10","used":"1","percent":10}}`, nil},
+		"without swap": {`{"Mem":{"total":"1000","used":"100","percent":10}}`, nil},
+		"null swap":    {`{"Mem":{"total":"1000","used":"100","percent":10},"Swap":null}`, nil},
+		"without mem":  {`{"Swap":{"total":"10","used":"1","percent":10}}`, []string{"Memory.Mem: required"}},
+		"invalid swap": {`{"Mem":{"total":"1000","used":"100","percent":10},"Swap":[]}`, []string{"Memory.Swap: expected object, got array"}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			report, err := ParseReport([]byte(`{"Info":{},"Collection":{"Memory":` + tt.memory + `}}`))
+			var verr *ValidationError
+			errors.As(err, &verr)
+			if tt.fields == nil {
+				if err != nil {
+					t.Fatalf("ParseReport() = %v", err)
+				}
+				if report.Collection.Memory.Mem.Total.Float() != 1000 {
+					t.Errorf("Memory = %+v", report.Collection.Memory)
+				}
+				return
+			}
+			if verr == nil || !reflect.DeepEqual(verr.Fields, tt.fields) {
+				t.Errorf("ParseReport() = %v, want fields %q", err, tt.fields)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/elliotchance/orderedmap/v3"
+)
+
+// RollupTier is a compacted copy of the raw collection holding one point per
+// Step seconds, kept for longer than DATA_RETENTION_DAYS.
+type RollupTier struct {
+	Name string
+	Step int64
+	// retentionDays reads the retention of the tier from the configuration.
+	retentionDays func(*Config) int
+}
+
+// RollupTiers are ordered from the finest to the coarsest.
+var RollupTiers = []RollupTier{
+	{Name: "5m", Step: 300, retentionDays: func(c *Config) int { return c.Data.Rollup5mRetentionDays }},
+	{Name: "1h", Step: 3600, retentionDays: func(c *Config) int { return c.Data.Rollup1hRetentionDays }},
+}
+
+// Series names the store series of the tier, e.g. system_monitor:rollup:5m:<uuid> in Redis.
+func (t RollupTier) Series() string {
+	return "rollup:" + t.Name
+}
+
+// Retention returns the age in seconds after which buckets are dropped.
+func (t RollupTier) Retention() int64 {
+	return int64(t.retentionDays(Conf())) * 86400
+}
+
+// rawRetention returns the age in seconds after which raw points are dropped.
+func rawRetention() int64 {
+	return int64(Conf().Data.RetentionDays) * 86400
+}
+
+// RollupFuncs are the aggregations kept in the rollup tiers.
+var RollupFuncs = []string{"avg", "min", "max"}
+
+// InRollup reports whether a range starting at start is older than the raw
+// points and read from the rollup tiers.
+func InRollup(start int64) bool {
+	return start > 0 && start < time.Now().Unix()-rawRetention()
+}
+
+// Rollup is the min/avg/max of every metric reported within one bucket.
+// Non numeric fields (battery status, ...) are those of the last point.
+type Rollup struct {
+	Time  int64          `json:"time"`
+	Count int            `json:"count"`
+	Min   CollectionData `json:"min"`
+	Avg   CollectionData `json:"avg"`
+	Max   CollectionData `json:"max"`
+}
+
+// Get returns the bucket value matching one of RollupFuncs.
+func (r Rollup) Get(fn string) (CollectionData, bool) {
+	switch fn {
+	case "avg":
+		return r.Avg, true
+	case "min":
+		return r.Min, true
+	case "max":
+		return r.Max, true
+	}
+	return CollectionData{}, false
+}
+
+var collectionSections = []string{"Disk", "Memory", "Load", "Network", "Thermal", "Battery", "IO", "Ping"}
+
+// setSection replaces the named section with the one of from.
+func (c *CollectionData) setSection(name string, from CollectionData) {
+	switch name {
+	case "Disk":
+		c.Disk = from.Disk
+	case "Memory":
+		c.Memory = from.Memory
+	case "Load":
+		c.Load = from.Load
+	case "Network":
+		c.Network = from.Network
+	case "Thermal":
+		c.Thermal = from.Thermal
+	case "Battery":
+		c.Battery = from.Battery
+	case "IO":
+		c.IO = from.IO
+	case "Ping":
+		c.Ping = from.Ping
+	}
+}
+
+func rollupCollections(collections *orderedmap.OrderedMap[int64, CollectionData], step int64) []*Rollup {
+	rollups := map[int64]*Rollup{}
+	result := []*Rollup{}
+	for score := range collections.Keys() {
+		bucket := score - score%step
+		r, ok := rollups[bucket]
+		if !ok {
+			r = &Rollup{
+				Time: bucket,
+				Min:  CollectionData{Version: CollectionVersion},
+				Avg:  CollectionData{Version: CollectionVersion},
+				Max:  CollectionData{Version: CollectionVersion},
+			}
+			rollups[bucket] = r
+			result = append(result, r)
+		}
+		r.Count++
+	}
+
+	for _, section := range collectionSections {
+		for fn, target := range map[string]func(*Rollup) *CollectionData{
+			"min": func(r *Rollup) *CollectionData { return &r.Min },
+			"avg": func(r *Rollup) *CollectionData { return &r.Avg },
+			"max": func(r *Rollup) *CollectionData { return &r.Max },
+		} {
+			points := Downsample(collections, section, Aggregation{Step: step, Func: fn})
+			for bucket, point := range points.AllFromFront() {
+				target(rollups[bucket]).setSection(section, point)
+			}
+		}
+	}
+	return result
+}
+
+// CompactCollectionData adds every complete bucket since the last compaction
+// to each rollup tier, then drops buckets past the tier retention.
+// Points reported for an already compacted bucket are not rolled up again.
+func CompactCollectionData(ctx context.Context, uuid string) error {
+	now := time.Now().Unix()
+
+	for _, tier := range RollupTiers {
+		from := now - tier.Retention()
+		last, err := DataStore.LastPoint(ctx, tier.Series(), uuid, 0)
+		if err != nil {
+			return err
+		}
+		if last != nil {
+			from = max(from, last.Time+tier.Step)
+		}
+		from -= from % tier.Step
+		// The current bucket is still filling up.
+		to := now - now%tier.Step
+
+		if from < to {
+			data, err := DataStore.RangePoints(ctx, SeriesCollection, uuid, from, to-1)
+			if err != nil {
+				return err
+			}
+
+			points := []Point{}
+			for _, r := range rollupCollections(decodeCollections(data), tier.Step) {
+				b, err := json.Marshal(r)
+				if err != nil {
+					return fmt.Errorf("failed to marshal rollup: %w", err)
+				}
+				points = append(points, Point{Time: r.Time, Data: string(b)})
+			}
+			if err := DataStore.AppendPoints(ctx, tier.Series(), uuid, points...); err != nil {
+				return err
+			}
+		}
+
+		if err := DataStore.Retain(ctx, tier.Series(), uuid, now-tier.Retention()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetCollectionHistory returns the points of uuid within [start, end] from the
+// finest data still covering start: raw points while within
+// DATA_RETENTION_DAYS, otherwise the first rollup tier that does, taking the
+// fn value of each bucket, an error unless fn is one of RollupFuncs. Raw points
+// newer than the last bucket are appended.
+func GetCollectionHistory(uuid string, start, end int64, fn string) (*orderedmap.OrderedMap[int64, CollectionData], error) {
+	if !InRollup(start) {
+		return GetCollectionByTime(uuid, false, start, end)
+	}
+	if !slices.Contains(RollupFuncs, fn) {
+		return nil, fmt.Errorf("agg %q is not kept beyond DATA_RETENTION_DAYS, expected one of %v", fn, RollupFuncs)
+	}
+	now := time.Now().Unix()
+	if end <= 0 {
+		end = now
+	}
+
+	tier := RollupTiers[len(RollupTiers)-1]
+	for _, t := range RollupTiers {
+		if start >= now-t.Retention() {
+			tier = t
+			break
+		}
+	}
+
+	data, err := DataStore.RangePoints(context.Background(), tier.Series(), uuid, start-start%tier.Step, end)
+	if err != nil {
+		return nil, err
+	}
+
+	result := orderedmap.NewOrderedMap[int64, CollectionData]()
+	from := start
+	for _, item := range data {
+		var r Rollup
+		if err := json.Unmarshal([]byte(item.Data), &r); err != nil {
+			fmt.Println("Error decoding rollup:", uuid, err)
+			continue
+		}
+		point, _ := r.Get(fn)
+		result.Set(r.Time, point)
+		from = r.Time + tier.Step
+	}
+
+	if from <= end {
+		raw, err := GetCollectionByTime(uuid, false, from, end)
+		if err != nil {
+			return nil, err
+		}
+		for t, v := range raw.AllFromFront() {
+			result.Set(t, v)
+		}
+	}
+	return result, nil
+}
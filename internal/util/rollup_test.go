@@ -0,0 +1,42 @@
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGetCollectionHistoryRollup(t *testing.T) {
+	s := setupTestStore(t)
+	SetupCollectionCache()
+	ctx := context.Background()
+	// Two points in the same 1h bucket, older than the raw retention.
+	bucket := time.Now().Unix() - 10*86400
+	bucket -= bucket % 3600
+	s.AppendPoints(ctx, SeriesCollection, "n1", loadPoint(bucket+60, 2), loadPoint(bucket+120, 4))
+	if err := CompactCollectionData(ctx, "n1"); err != nil {
+		t.Fatal(err)
+	}
+
+	start := bucket - 86400
+	for fn, want := range map[string]float64{"min": 2, "avg": 3, "max": 4} {
+		history, err := GetCollectionHistory("n1", start, 0, fn)
+		if err != nil {
+			t.Fatalf("%s: %v", fn, err)
+		}
+		point, ok := history.Get(bucket)
+		if !ok {
+			t.Fatalf("%s: no point at %d in %v", fn, bucket, history.Keys())
+		}
+		if v, _ := point.Lookup("Load", "user"); v != want {
+			t.Errorf("%s = %v, want %v", fn, v, want)
+		}
+	}
+
+	// The rollups do not hold them, they must not silently fall back to avg.
+	for _, fn := range []string{"p95", "last"} {
+		if _, err := GetCollectionHistory("n1", start, 0, fn); err == nil {
+			t.Errorf("GetCollectionHistory(%s) of a rolled up range = nil error", fn)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package util
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Session is the payload of the signed admin session cookie.
+type Session struct {
+	// ID names the session in the store once it is revoked.
+	ID      string `json:"i"`
+	User    string `json:"u"`
+	Expires int64  `json:"e"`
+	CSRF    string `json:"c"`
+}
+
+var (
+	sessionSecret     []byte
+	sessionSecretOnce sync.Once
+)
+
+var ErrInvalidSession = errors.New("invalid session")
+
+// getSessionSecret returns SESSION_SECRET, or a random key when it is not set.
+// With a random key every session is invalidated on restart.
+func getSessionSecret() []byte {
+	sessionSecretOnce.Do(func() {
+		if s := Conf().Admin.SessionSecret; s != "" {
+			sessionSecret = []byte(s)
+			return
+		}
+		fmt.Println("SESSION_SECRET is not set, admin sessions will not survive a restart")
+		sessionSecret = make([]byte, 32)
+		_, _ = rand.Read(sessionSecret)
+	})
+	return sessionSecret
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func signSession(payload string) string {
+	mac := hmac.New(sha256.New, getSessionSecret())
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// NewSession creates a session for user and returns it with its cookie value.
+func NewSession(user string, ttl time.Duration) (*Session, string, error) {
+	s := &Session{ID: randomHex(16), User: user, Expires: time.Now().Add(ttl).Unix(), CSRF: randomHex(16)}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return s, payload + "." + signSession(payload), nil
+}
+
+// ParseSession verifies the signature and expiry of a cookie value, and that
+// the session was not revoked.
+func ParseSession(value string) (*Session, error) {
+	payload, signature, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(signSession(payload))) {
+		return nil, ErrInvalidSession
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidSession
+	}
+	s := &Session{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, ErrInvalidSession
+	}
+	if time.Now().Unix() > s.Expires || s.ID == "" {
+		return nil, ErrInvalidSession
+	}
+	revoked, err := DataStore.GetCounter(context.Background(), "session_revoked:"+s.ID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked > 0 {
+		return nil, ErrInvalidSession
+	}
+	return s, nil
+}
+
+// RevokeSession invalidates s until it expires, so that a copy of its cookie
+// stops working after logout.
+func RevokeSession(s *Session) error {
+	ttl := time.Until(time.Unix(s.Expires, 0)) + time.Second
+	_, err := DataStore.IncrCounter(context.Background(), "session_revoked:"+s.ID, ttl)
+	return err
+}
+
+// GetAdminPasswordHash returns the bcrypt hash from ADMIN_PASSWORD_HASH,
+// or from the file named by ADMIN_PASSWORD_FILE.
+func GetAdminPasswordHash() string {
+	if h := Conf().Admin.PasswordHash; h != "" {
+		return h
+	}
+	if f := Conf().Admin.PasswordFile; f != "" {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			fmt.Println("Error reading ADMIN_PASSWORD_FILE:", err)
+			return ""
+		}
+		return strings.TrimSpace(string(data))
+	}
+	return ""
+}
+
+// CheckAdminCredentials compares user and password against ADMIN_USERNAME
+// and the configured bcrypt hash.
+func CheckAdminCredentials(user, password string) bool {
+	hash := GetAdminPasswordHash()
+	if hash == "" {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(Conf().Admin.Username)) == 1
+	passOK := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	return userOK && passOK
+}
+
+// HashPassword returns the bcrypt hash to be used as ADMIN_PASSWORD_HASH.
+func HashPassword(password string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(h), err
+}
+
+// RecordLoginAttempt counts an attempt of ip before its password is checked
+// and reports whether it is still within ADMIN_MAX_LOGIN_ATTEMPTS, so that
+// concurrent attempts cannot all pass. The counter expires after
+// ADMIN_LOCKOUT_SECONDS, which is also how long a locked out ip has to wait,
+// and is reset by a successful login.
+func RecordLoginAttempt(ip string) (bool, error) {
+	n, err := DataStore.IncrCounter(context.Background(), "login_fail:"+ip, time.Duration(Conf().Admin.LockoutSeconds)*time.Second)
+	if err != nil {
+		return false, err
+	}
+	return n <= int64(Conf().Admin.MaxLoginAttempts), nil
+}
+
+func ResetLoginFailures(ip string) error {
+	return DataStore.ResetCounter(context.Background(), "login_fail:"+ip)
+}
@@ -0,0 +1,159 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SeriesCollection is the series holding the raw points reported by a node.
+// Rollup tiers are stored as their own series, see RollupTier.Series.
+const SeriesCollection = "collection"
+
+// Point is a JSON encoded document scored by its unix timestamp.
+type Point struct {
+	Time int64
+	Data string
+}
+
+// NodeUpdate is everything written at once when a node reports.
+type NodeUpdate struct {
+	Point   Point
+	Info    map[string]string
+	Address string
+	// AliveTTL is how long the node is considered online without reporting again.
+	AliveTTL time.Duration
+}
+
+// Store is the storage backend of nodes, their time series and the little
+// shared state (tokens, alert states, login counters, events) around them.
+// Missing nodes, fields and keys are never errors: getters return empty values.
+type Store interface {
+	// ListNodes returns every known node mapped to its address.
+	ListNodes(ctx context.Context) (map[string]string, error)
+	GetInfo(ctx context.Context, uuid string) (map[string]string, error)
+	IsAlive(ctx context.Context, uuid string) (bool, error)
+	// SaveReport appends the point and updates the info, address and alive
+	// marker of uuid atomically.
+	SaveReport(ctx context.Context, uuid string, u NodeUpdate) error
+	// DeleteNode removes every series, field and key belonging to uuid.
+	DeleteNode(ctx context.Context, uuid string) error
+	// Purge removes what is left of the per node hashes once every node is deleted.
+	Purge(ctx context.Context) error
+
+	GetNames(ctx context.Context) (map[string]string, error)
+	// SetName renames a node, an empty name removes it.
+	SetName(ctx context.Context, uuid, name string) error
+	GetHidden(ctx context.Context) (map[string]string, error)
+	SetHidden(ctx context.Context, uuid string, hidden bool) error
+	GetStatus(ctx context.Context, uuid string) (string, error)
+	SetStatus(ctx context.Context, uuid, status string) error
+	GetToken(ctx context.Context, uuid string) (string, error)
+	SetToken(ctx context.Context, uuid, token string) error
+	GetAlertStates(ctx context.Context, uuid string) (map[string]string, error)
+	SetAlertState(ctx context.Context, uuid, rule, state string) error
+	DeleteAlertState(ctx context.Context, uuid, rule string) error
+
+	AppendPoints(ctx context.Context, series, uuid string, points ...Point) error
+	// RangePoints returns the points scored within [start, end], oldest first.
+	RangePoints(ctx context.Context, series, uuid string, start, end int64) ([]Point, error)
+	// LastPoint returns the newest point scored before before, or any point
+	// when before <= 0. It returns nil when there is none.
+	LastPoint(ctx context.Context, series, uuid string, before int64) (*Point, error)
+	CountPoints(ctx context.Context, series, uuid string) (int64, error)
+	// Retain drops the points scored at or before cutoff.
+	Retain(ctx context.Context, series, uuid string, cutoff int64) error
+
+	// Claim sets key for ttl, returning false when it is already set.
+	Claim(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	GetCounter(ctx context.Context, key string) (int64, error)
+	// IncrCounter increments key and (re)sets its expiration to ttl.
+	IncrCounter(ctx context.Context, key string, ttl time.Duration) (int64, error)
+	ResetCounter(ctx context.Context, key string) error
+
+	Publish(ctx context.Context, channel string, payload []byte) error
+	// Subscribe calls handler for every message published on channel until ctx is done.
+	Subscribe(ctx context.Context, channel string, handler func(payload []byte)) error
+
+	Close() error
+}
+
+// DataStore is the global storage backend.
+// Initialize it in main() via SetupStore() and close with CloseStore().
+var DataStore Store
+
+// SetupStore opens the backend selected by STORE:
+// "redis" (default) connects using the REDIS_* env vars (see SetupRedis),
+// "bolt" opens the embedded database at BOLT_PATH (default: ./data/monitor.db),
+// "memory" keeps everything in process memory until exit.
+func SetupStore() error {
+	switch backend := Conf().Store.Backend; backend {
+	case "redis":
+		if err := SetupRedis(); err != nil {
+			return err
+		}
+		DataStore = NewRedisStore(RedisClient)
+	case "bolt":
+		s, err := OpenBoltStore(Conf().Store.BoltPath)
+		if err != nil {
+			return err
+		}
+		DataStore = s
+	case "memory":
+		DataStore = NewMemoryStore()
+	default:
+		return fmt.Errorf("unknown STORE %q", backend)
+	}
+	return nil
+}
+
+// CloseStore closes the global store if it's initialized.
+func CloseStore() error {
+	if DataStore == nil {
+		return nil
+	}
+	return DataStore.Close()
+}
+
+// localBus delivers published messages to the subscribers of this process.
+type localBus struct {
+	mu       sync.Mutex
+	next     int
+	handlers map[string]map[int]func([]byte)
+}
+
+func newLocalBus() *localBus {
+	return &localBus{handlers: map[string]map[int]func([]byte){}}
+}
+
+func (b *localBus) publish(channel string, payload []byte) {
+	b.mu.Lock()
+	handlers := make([]func([]byte), 0, len(b.handlers[channel]))
+	for _, h := range b.handlers[channel] {
+		handlers = append(handlers, h)
+	}
+	b.mu.Unlock()
+
+	for _, h := range handlers {
+		h(payload)
+	}
+}
+
+func (b *localBus) subscribe(ctx context.Context, channel string, handler func([]byte)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	if b.handlers[channel] == nil {
+		b.handlers[channel] = map[int]func([]byte){}
+	}
+	b.handlers[channel][id] = handler
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.handlers[channel], id)
+	}()
+}
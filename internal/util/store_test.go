@@ -0,0 +1,273 @@
+package util
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// stores returns one instance of every Store implementation.
+func stores(t *testing.T) map[string]Store {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	bolt, err := OpenBoltStore(filepath.Join(t.TempDir(), "monitor.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := map[string]Store{
+		"redis":  NewRedisStore(NewRedisClient(mr.Addr(), "", 0, nil)),
+		"bolt":   bolt,
+		"memory": NewMemoryStore(),
+	}
+	t.Cleanup(func() {
+		for _, s := range result {
+			s.Close()
+		}
+	})
+	return result
+}
+
+func TestStoreCloseTwice(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			// Closing again must not panic; redis reports it is already closed.
+			s.Close()
+		})
+	}
+}
+
+func TestStoreReport(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			err := s.SaveReport(ctx, "n1", NodeUpdate{
+				Point:    Point{Time: 100, Data: `{"Load":{"user":1}}`},
+				Info:     map[string]string{"IPV4": "1.2.3.4"},
+				Address:  "1.2.3.4",
+				AliveTTL: time.Minute,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			nodes, _ := s.ListNodes(ctx)
+			if !reflect.DeepEqual(nodes, map[string]string{"n1": "1.2.3.4"}) {
+				t.Errorf("ListNodes = %v", nodes)
+			}
+			info, _ := s.GetInfo(ctx, "n1")
+			if info["IPV4"] != "1.2.3.4" {
+				t.Errorf("GetInfo = %v", info)
+			}
+			if alive, _ := s.IsAlive(ctx, "n1"); !alive {
+				t.Error("IsAlive = false after a report")
+			}
+			if alive, _ := s.IsAlive(ctx, "n2"); alive {
+				t.Error("IsAlive = true for an unknown node")
+			}
+			if info, err := s.GetInfo(ctx, "n2"); err != nil || len(info) != 0 {
+				t.Errorf("GetInfo of an unknown node = %v, %v", info, err)
+			}
+
+			s.SetName(ctx, "n1", "web")
+			s.SetHidden(ctx, "n1", true)
+			s.SetToken(ctx, "n1", "secret")
+			s.SetStatus(ctx, "n1", "1")
+			s.SetAlertState(ctx, "n1", "cpu", "{}")
+			if names, _ := s.GetNames(ctx); names["n1"] != "web" {
+				t.Errorf("GetNames = %v", names)
+			}
+			if hidden, _ := s.GetHidden(ctx); hidden["n1"] != "1" {
+				t.Errorf("GetHidden = %v", hidden)
+			}
+			if token, _ := s.GetToken(ctx, "n1"); token != "secret" {
+				t.Errorf("GetToken = %q", token)
+			}
+			if status, _ := s.GetStatus(ctx, "n1"); status != "1" {
+				t.Errorf("GetStatus = %q", status)
+			}
+			if states, _ := s.GetAlertStates(ctx, "n1"); states["cpu"] != "{}" {
+				t.Errorf("GetAlertStates = %v", states)
+			}
+
+			s.SetName(ctx, "n1", "")
+			s.SetHidden(ctx, "n1", false)
+			s.DeleteAlertState(ctx, "n1", "cpu")
+			if names, _ := s.GetNames(ctx); len(names) != 0 {
+				t.Errorf("GetNames after reset = %v", names)
+			}
+			if hidden, _ := s.GetHidden(ctx); len(hidden) != 0 {
+				t.Errorf("GetHidden after reset = %v", hidden)
+			}
+			if states, _ := s.GetAlertStates(ctx, "n1"); len(states) != 0 {
+				t.Errorf("GetAlertStates after delete = %v", states)
+			}
+
+			s.AppendPoints(ctx, RollupTiers[0].Series(), "n1", Point{Time: 0, Data: "{}"})
+			if err := s.DeleteNode(ctx, "n1"); err != nil {
+				t.Fatal(err)
+			}
+			if nodes, _ := s.ListNodes(ctx); len(nodes) != 0 {
+				t.Errorf("ListNodes after delete = %v", nodes)
+			}
+			if token, _ := s.GetToken(ctx, "n1"); token != "" {
+				t.Errorf("GetToken after delete = %q", token)
+			}
+			for _, series := range []string{SeriesCollection, RollupTiers[0].Series()} {
+				if n, _ := s.CountPoints(ctx, series, "n1"); n != 0 {
+					t.Errorf("CountPoints(%s) after delete = %d", series, n)
+				}
+			}
+			if alive, _ := s.IsAlive(ctx, "n1"); alive {
+				t.Error("IsAlive = true after delete")
+			}
+		})
+	}
+}
+
+func TestStorePoints(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			if p, err := s.LastPoint(ctx, SeriesCollection, "n1", 0); p != nil || err != nil {
+				t.Errorf("LastPoint of an empty series = %v, %v", p, err)
+			}
+			err := s.AppendPoints(ctx, SeriesCollection, "n1",
+				Point{Time: 30, Data: "c"},
+				Point{Time: 10, Data: "a"},
+				Point{Time: 20, Data: "b"},
+				Point{Time: 40, Data: "d"},
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tests := []struct {
+				start, end int64
+				want       []Point
+			}{
+				{0, 100, []Point{{10, "a"}, {20, "b"}, {30, "c"}, {40, "d"}}},
+				{20, 30, []Point{{20, "b"}, {30, "c"}}},
+				{21, 29, []Point{}},
+				{50, 60, []Point{}},
+			}
+			for _, tt := range tests {
+				got, err := s.RangePoints(ctx, SeriesCollection, "n1", tt.start, tt.end)
+				if err != nil || !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("RangePoints(%d, %d) = %v, %v; want %v", tt.start, tt.end, got, err, tt.want)
+				}
+			}
+
+			for before, want := range map[int64]*Point{0: {40, "d"}, 40: {30, "c"}, 35: {30, "c"}, 10: nil} {
+				got, _ := s.LastPoint(ctx, SeriesCollection, "n1", before)
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("LastPoint(%d) = %v, want %v", before, got, want)
+				}
+			}
+
+			if err := s.Retain(ctx, SeriesCollection, "n1", 20); err != nil {
+				t.Fatal(err)
+			}
+			if n, _ := s.CountPoints(ctx, SeriesCollection, "n1"); n != 2 {
+				t.Errorf("CountPoints after Retain = %d, want 2", n)
+			}
+			if n, _ := s.CountPoints(ctx, RollupTiers[0].Series(), "n1"); n != 0 {
+				t.Errorf("CountPoints of another series = %d, want 0", n)
+			}
+		})
+	}
+}
+
+func TestStoreKeys(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			if ok, _ := s.Claim(ctx, "lock", time.Minute); !ok {
+				t.Error("first Claim = false")
+			}
+			if ok, _ := s.Claim(ctx, "lock", time.Minute); ok {
+				t.Error("second Claim = true")
+			}
+
+			for i := int64(1); i <= 3; i++ {
+				if n, err := s.IncrCounter(ctx, "fail", time.Minute); n != i || err != nil {
+					t.Errorf("IncrCounter = %d, %v; want %d", n, err, i)
+				}
+			}
+			if n, _ := s.GetCounter(ctx, "fail"); n != 3 {
+				t.Errorf("GetCounter = %d, want 3", n)
+			}
+			s.ResetCounter(ctx, "fail")
+			if n, _ := s.GetCounter(ctx, "fail"); n != 0 {
+				t.Errorf("GetCounter after reset = %d, want 0", n)
+			}
+		})
+	}
+}
+
+func TestStoreExpiration(t *testing.T) {
+	ctx := context.Background()
+	for name, s := range stores(t) {
+		if name == "redis" {
+			// miniredis only expires keys when its clock is moved forward.
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			s.Claim(ctx, "lock", 10*time.Millisecond)
+			s.IncrCounter(ctx, "fail", 10*time.Millisecond)
+			time.Sleep(20 * time.Millisecond)
+			if ok, _ := s.Claim(ctx, "lock", time.Minute); !ok {
+				t.Error("Claim of an expired key = false")
+			}
+			if n, _ := s.GetCounter(ctx, "fail"); n != 0 {
+				t.Errorf("GetCounter of an expired key = %d, want 0", n)
+			}
+		})
+	}
+}
+
+func TestStorePublish(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			received := make(chan string, 1)
+			if err := s.Subscribe(ctx, "events", func(payload []byte) { received <- string(payload) }); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Publish(ctx, "events", []byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case got := <-received:
+				if got != "hello" {
+					t.Errorf("received %q", got)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("no message received")
+			}
+		})
+	}
+}
+
+// setupTestStore points the package at an empty MemoryStore and fresh caches.
+func setupTestStore(t *testing.T) *MemoryStore {
+	t.Helper()
+	old := DataStore
+	s := NewMemoryStore()
+	DataStore = s
+	SetupMapStringCache()
+	setupTestDiskCache(t)
+	t.Cleanup(func() {
+		DataStore = old
+		s.Close()
+	})
+	return s
+}
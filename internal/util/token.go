@@ -0,0 +1,34 @@
+package util
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// GetReportToken returns the shared secret of a node from `system_monitor:token`.
+// An empty string means that no secret has been registered for uuid.
+func GetReportToken(uuid string) (string, error) {
+	return DataStore.GetToken(context.Background(), uuid)
+}
+
+// SetReportToken stores the shared secret of a node.
+func SetReportToken(uuid, token string) error {
+	return DataStore.SetToken(context.Background(), uuid, token)
+}
+
+// GenerateReportToken returns a random 32 bytes hex encoded secret.
+func GenerateReportToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ClaimReportNonce marks a signature as used for ttl.
+// It returns false when the signature has already been seen, i.e. the request is a replay.
+func ClaimReportNonce(uuid, signature string, ttl time.Duration) (bool, error) {
+	return DataStore.Claim(context.Background(), "nonce:"+uuid+":"+signature, ttl)
+}
@@ -1,36 +1,151 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/LittleJake/server-monitor-go/internal/notifier"
 	"github.com/LittleJake/server-monitor-go/internal/util"
 )
 
 // optional: minimal main to run the router
 func main() {
-	// load .env
-	_ = util.LoadEnv()
+	// `server-monitor-go hash-password` reads a password from stdin and prints
+	// the bcrypt hash to be used as ADMIN_PASSWORD_HASH.
+	if len(os.Args) > 1 && os.Args[1] == "hash-password" {
+		password, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		hash, err := util.HashPassword(strings.TrimRight(password, "\r\n"))
+		if err != nil {
+			log.Fatalf("Failed to hash password: %v", err)
+		}
+		fmt.Println(hash)
+		return
+	}
 
-	// Setup Redis client and test connection
-	if err := util.SetupRedis(); err != nil {
-		log.Fatalf("Failed to setup Redis: %v", err)
+	// load .env, then the configuration
+	if err := util.LoadEnv(); err != nil {
+		log.Fatalf("Failed to load .env: %v", err)
+	}
+	if err := util.SetupConfig(); err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Setup the storage backend (Redis by default) and test the connection
+	if err := util.SetupStore(); err != nil {
+		log.Fatalf("Failed to setup store: %v", err)
 	}
-	defer func() {
-		if err := util.CloseRedisClient(); err != nil {
-			log.Printf("Error closing Redis: %v", err)
-		}
-	}()
 
 	util.SetupCollectionCache()
 	util.SetupMapStringCache()
 	util.SetupCollectionStatusCache()
 	util.SetupDiskCache()
 
-	r := SetupRouter()
+	if err := util.LoadAlertRules(); err != nil {
+		log.Fatalf("Failed to load alert rules: %v", err)
+	}
+	util.RegisterNotifier(util.LogNotifier{})
+	notifiers, err := notifier.FromConfig(util.Conf().Alert)
+	if err != nil {
+		log.Fatalf("Failed to set up notifiers: %v", err)
+	}
+	for _, n := range notifiers {
+		util.RegisterNotifier(n)
+	}
+
+	go reloadOnSIGHUP()
+
+	// SIGINT or SIGTERM stops the server and the cron tasks, a second one
+	// exits immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		// Restore the default behavior for the second signal.
+		stop()
+	}()
+
+	cronDone := make(chan struct{})
+	go func() {
+		util.CronJob(ctx)
+		close(cronDone)
+	}()
+
+	if err := runServer(ctx, SetupRouter(), cronDone); err != nil {
+		log.Printf("Server error: %v", err)
+	}
+
+	// Closing the store under running cron tasks would fail them halfway.
+	select {
+	case <-cronDone:
+		if err := util.CloseStore(); err != nil {
+			log.Printf("Error closing store: %v", err)
+		}
+	default:
+		log.Printf("Exiting without closing the store, cron tasks are still running")
+	}
+}
 
-	go util.CronJob()
+// runServer serves r until ctx is done, then waits up to SHUTDOWN_TIMEOUT
+// seconds for in-flight requests to complete and cronDone to be closed.
+// Event streams are ended as soon as the shutdown starts.
+func runServer(ctx context.Context, r http.Handler, cronDone <-chan struct{}) error {
+	srv := &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", util.Conf().Server.ListenAddress, util.Conf().Server.ListenPort),
+		Handler: r,
+	}
+	srv.RegisterOnShutdown(util.Events.Close)
+
+	errs := make(chan error, 1)
+	go func() {
+		fmt.Printf("starting server on %s\n", srv.Addr)
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
 
-	fmt.Printf("starting server on %s:%d", util.GetEnv("LISTEN_ADDRESS", "127.0.0.1"), util.GetEnvInt("LISTEN_PORT", 8888))
-	_ = r.Run(fmt.Sprintf("%s:%d", util.GetEnv("LISTEN_ADDRESS", "127.0.0.1"), util.GetEnvInt("LISTEN_PORT", 8888)))
+	log.Printf("Shutting down")
+	timeout := time.Duration(util.Conf().Server.ShutdownTimeout) * time.Second
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := srv.Shutdown(shutdownCtx)
+	select {
+	case <-cronDone:
+	case <-shutdownCtx.Done():
+		log.Printf("Cron tasks still running after %s", timeout)
+	}
+	return err
+}
+
+// reloadOnSIGHUP reloads the configuration and the alert rules on SIGHUP.
+func reloadOnSIGHUP() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		restart, err := util.ReloadConfig()
+		if err != nil {
+			log.Printf("Failed to reload configuration: %v", err)
+			continue
+		}
+		if len(restart) > 0 {
+			log.Printf("Restart to apply: %s", strings.Join(restart, ", "))
+		}
+		if err := util.LoadAlertRules(); err != nil {
+			log.Printf("Failed to reload alert rules: %v", err)
+			continue
+		}
+		log.Printf("Configuration reloaded")
+	}
 }
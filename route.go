@@ -16,7 +16,6 @@ import (
 	"github.com/LittleJake/server-monitor-go/internal/controller/api"
 	"github.com/LittleJake/server-monitor-go/internal/middleware"
 	"github.com/LittleJake/server-monitor-go/internal/util"
-	"github.com/elliotchance/orderedmap/v3"
 	"github.com/gin-contrib/i18n"
 
 	"github.com/gin-gonic/gin"
@@ -27,7 +26,7 @@ func SetupRouter() *gin.Engine {
 	// parse bool from environment variable `WEB.IS_DEBUG`.
 	// Accepts common boolean string values like: 1, t, T, TRUE, true, True, 0, f, F, FALSE, false, False
 	isDebug := false
-	if b := util.GetEnvBool("IS_DEBUG", false); b {
+	if b := util.Conf().Server.Debug; b {
 		isDebug = b
 	}
 
@@ -40,12 +39,80 @@ func SetupRouter() *gin.Engine {
 
 	r := gin.New()
 
+	// Only trust X-Forwarded-For from the proxies listed in TRUSTED_PROXIES,
+	// otherwise the client IP used by the admin login lockout could be spoofed.
+	if err := r.SetTrustedProxies(trustedProxies(util.Conf().Server.TrustedProxies)); err != nil {
+		fmt.Println("Invalid TRUSTED_PROXIES:", err.Error())
+	}
+
 	// Built-in middleware
 	r.Use(gin.Logger())
 	r.Use(middleware.ServerDataMiddleware())
 	r.Use(middleware.GinI18nLocalize())
 
-	funcMap := template.FuncMap{
+	r.SetHTMLTemplate(template.Must(template.New("").Funcs(templateFuncs()).ParseFS(assets.TemplatesFS, "templates/**/*.html")))
+
+	// Use the default Recovery in debug mode so developers see panics.
+	// In non-debug (production) mode, use a custom recovery that renders
+	// a friendly error page instead of exposing stack traces.
+
+	r.Use(gin.CustomRecovery(controller.Error.InternalServerError))
+
+	// In non-debug mode, route unknown paths and methods to the error page.
+	r.NoRoute(controller.Error.NoRouteError)
+	r.NoMethod(controller.Error.NoMethodError)
+
+	r.Use(middleware.CORS)
+
+	r.GET("/", controller.Index.Index)
+
+	// Hidden nodes are only shown in the admin panel.
+	visiblePage := middleware.VisibleNode(controller.Error.NoRouteError)
+	visibleAPI := middleware.VisibleNode(func(c *gin.Context) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "node not found"})
+	})
+
+	// Info routes
+	r.GET("/info/:uuid", visiblePage, controller.Index.Info)
+	r.GET("/list/", controller.Index.List)
+
+	// Prometheus exporter
+	r.GET("/metrics", controller.Metrics.Get)
+
+	// API group
+	_api := r.Group("/api")
+	{
+		_api.GET("/cpu/:uuid", visibleAPI, api.Cpu.Get)
+		_api.GET("/memory/:uuid", visibleAPI, api.Memory.Get)
+		_api.GET("/disk/:uuid", visibleAPI, api.Disk.Get)
+		_api.GET("/network/:uuid", visibleAPI, api.Network.Get)
+		_api.GET("/io/:uuid", visibleAPI, api.IO.Get)
+		_api.GET("/ping/:uuid", visibleAPI, api.Ping.Get)
+		_api.GET("/thermal/:uuid", visibleAPI, api.Thermal.Get)
+		_api.GET("/battery/:uuid", visibleAPI, api.Battery.Get)
+		_api.GET("/stream", api.Stream.Get)
+
+		_api.POST("/report/:uuid", middleware.ReportAuth(), api.Report.Set)
+	}
+
+	// Admin group
+	SetupAdminRouter(r)
+
+	static, _ := fs.Sub(assets.StaticFS, "static")
+	// Serve static files (example)
+	r.StaticFS("/static", http.FS(static))
+
+	// Serve embedded favicon.ico
+	r.GET("/favicon.ico", assets.ServeFavicon)
+
+	r.GET("/manifest.json", assets.ServeManifest)
+
+	return r
+}
+
+// templateFuncs returns the helpers available to every HTML template.
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
 		"upper": strings.ToUpper,
 		"lower": strings.ToLower,
 		"trim":  strings.TrimSpace,
@@ -93,7 +160,7 @@ func SetupRouter() *gin.Engine {
 		},
 
 		"sizeFormat": func(v any) string {
-			size, _ := strconv.ParseFloat(v.(string), 64)
+			size, _ := strconv.ParseFloat(fmt.Sprint(v), 64)
 
 			units := []string{"MB", "GB", "TB", "PB", "EB", "ZB", "YB"}
 
@@ -109,15 +176,19 @@ func SetupRouter() *gin.Engine {
 			return fmt.Sprintf("%x", md5.Sum([]byte(v.(string))))
 		},
 		"locale": i18n.GetMessage,
+		// count returns the length of maps, slices and orderedmaps, 0 otherwise.
 		"count": func(v any) int {
-			if reflect.TypeOf(v) == reflect.TypeOf([]string{}) {
-				return len(v.([]string))
+			rv := reflect.ValueOf(v)
+			switch rv.Kind() {
+			case reflect.Map, reflect.Slice, reflect.Array:
+				return rv.Len()
+			case reflect.Pointer:
+				if rv.IsNil() {
+					return 0
+				}
 			}
-			if reflect.TypeOf(v) == reflect.TypeOf(map[string]interface{}{}) {
-				return len(v.(map[string]interface{}))
-			}
-			if reflect.TypeOf(v) == reflect.TypeOf(orderedmap.OrderedMap[any, any]{}) {
-				return v.(*orderedmap.OrderedMap[any, any]).Len()
+			if m, ok := v.(interface{ Len() int }); ok {
+				return m.Len()
 			}
 			return 0
 		},
@@ -128,60 +199,16 @@ func SetupRouter() *gin.Engine {
 			return fmt.Sprintf("%s", v)
 		},
 	}
+}
 
-	r.SetHTMLTemplate(template.Must(template.New("").Funcs(funcMap).ParseFS(assets.TemplatesFS, "templates/**/*.html")))
-
-	// Use the default Recovery in debug mode so developers see panics.
-	// In non-debug (production) mode, use a custom recovery that renders
-	// a friendly error page instead of exposing stack traces.
-
-	r.Use(gin.CustomRecovery(controller.Error.InternalServerError))
-
-	// In non-debug mode, route unknown paths and methods to the error page.
-	r.NoRoute(controller.Error.NoRouteError)
-	r.NoMethod(controller.Error.NoMethodError)
-
-	r.Use(middleware.CORS)
-
-	r.GET("/", controller.Index.Index)
-
-	// Info routes
-	r.GET("/info/:uuid", controller.Index.Info)
-	r.GET("/list/", controller.Index.List)
-
-	// API group
-	_api := r.Group("/api")
-	{
-		_api.GET("/cpu/:uuid", api.Cpu.Get)
-		_api.GET("/memory/:uuid", api.Memory.Get)
-		_api.GET("/disk/:uuid", api.Disk.Get)
-		_api.GET("/network/:uuid", api.Network.Get)
-		_api.GET("/io/:uuid", api.IO.Get)
-		_api.GET("/ping/:uuid", api.Ping.Get)
-		_api.GET("/thermal/:uuid", api.Thermal.Get)
-		_api.GET("/battery/:uuid", api.Battery.Get)
-
-		_api.POST("/report/:uuid", api.Report.Set)
+// trustedProxies splits the comma separated TRUSTED_PROXIES, ignoring the
+// spaces around each entry.
+func trustedProxies(v string) []string {
+	var proxies []string
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
 	}
-
-	// Admin group with simple basic-auth middleware example
-	// admin := r.Group("/admin", gin.BasicAuth(gin.Accounts{
-	// 	"admin": "password", // DON"T use hardcoded credentials in production
-	// }))
-	// {
-	// 	admin.GET("/dashboard", func(c *gin.Context) {
-	// 		user := c.MustGet(gin.AuthUserKey).(string)
-	// 		c.JSON(http.StatusOK, gin.H{"message": "welcome to admin dashboard", "user": user})
-	// 	})
-	// }
-	static, _ := fs.Sub(assets.StaticFS, "static")
-	// Serve static files (example)
-	r.StaticFS("/static", http.FS(static))
-
-	// Serve embedded favicon.ico
-	r.GET("/favicon.ico", assets.ServeFavicon)
-
-	r.GET("/manifest.json", assets.ServeManifest)
-
-	return r
+	return proxies
 }
@@ -0,0 +1,587 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/LittleJake/server-monitor-go/internal/util"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/elliotchance/orderedmap/v3"
+	"github.com/gin-gonic/gin"
+	"github.com/peterbourgon/diskv/v3"
+)
+
+func TestTemplateSizeFormat(t *testing.T) {
+	sizeFormat := templateFuncs()["sizeFormat"].(func(any) string)
+	tests := []struct {
+		in   any
+		want string
+	}{
+		{0, "0.00 MB"},
+		{512, "512.00 MB"},
+		{"512", "512.00 MB"},
+		{1024, "1.00 GB"},
+		{1536.0, "1.50 GB"},
+		{int64(1024 * 1024), "1.00 TB"},
+		{"garbage", "0.00 MB"},
+		{nil, "0.00 MB"},
+	}
+	for _, tt := range tests {
+		if got := sizeFormat(tt.in); got != tt.want {
+			t.Errorf("sizeFormat(%#v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTemplateDatetime(t *testing.T) {
+	datetime := templateFuncs()["datetime"].(func(any) string)
+	want := time.Unix(1700000000, 0).Format("2006-01-02 15:04:05")
+	tests := []struct {
+		in   any
+		want string
+	}{
+		{int64(1700000000), want},
+		{"1700000000", want},
+		{"1700000000.9", want},
+		{1700000000, ""},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := datetime(tt.in); got != tt.want {
+			t.Errorf("datetime(%#v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTemplateCount(t *testing.T) {
+	count := templateFuncs()["count"].(func(any) int)
+	points := orderedmap.NewOrderedMap[int64, util.CollectionData]()
+	points.Set(1, util.CollectionData{})
+	points.Set(2, util.CollectionData{})
+	var nilPoints *orderedmap.OrderedMap[int64, util.CollectionData]
+	tests := []struct {
+		name string
+		in   any
+		want int
+	}{
+		// The index passes the online and offline lists of GetCollectionStatus.
+		{"status map", map[string]interface{}{"a": 1, "b": 2}, 2},
+		{"empty status map", map[string]interface{}{}, 0},
+		{"nil status map", map[string]interface{}(nil), 0},
+		{"string map", map[string]string{"a": "b"}, 1},
+		{"slice", []string{"a", "b", "c"}, 3},
+		{"orderedmap", points, 2},
+		{"nil orderedmap", nilPoints, 0},
+		{"unsupported", 42, 0},
+		{"nil", nil, 0},
+	}
+	for _, tt := range tests {
+		if got := count(tt.in); got != tt.want {
+			t.Errorf("count(%s) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	tests := map[string][]string{
+		"":                       nil,
+		"10.0.0.1":               {"10.0.0.1"},
+		"10.0.0.1, 10.0.0.0/8 ,": {"10.0.0.1", "10.0.0.0/8"},
+	}
+	for in, want := range tests {
+		if got := trustedProxies(in); !reflect.DeepEqual(got, want) {
+			t.Errorf("trustedProxies(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestTemplateDefault(t *testing.T) {
+	def := templateFuncs()["default"].(func(any, any) any)
+	tests := []struct {
+		in   any
+		want any
+	}{
+		{nil, "Unknown"},
+		{"", "Unknown"},
+		{"Linux", "Linux"},
+	}
+	for _, tt := range tests {
+		if got := def(tt.in, "Unknown"); got != tt.want {
+			t.Errorf("default(%#v) = %#v, want %#v", tt.in, got, tt.want)
+		}
+	}
+}
+
+const testReportToken = "secret"
+
+// setTestConfig applies fn to a copy of the configuration for the duration
+// of the test.
+func setTestConfig(t *testing.T, fn func(*util.Config)) {
+	t.Helper()
+	old := util.Conf()
+	c := *old
+	fn(&c)
+	util.SetConfig(&c)
+	t.Cleanup(func() { util.SetConfig(old) })
+}
+
+// setupTestRouter serves the router from a miniredis backed store with the
+// admin panel enabled (user admin, password pw) and node n1 registered.
+func setupTestRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.DefaultWriter = io.Discard
+
+	hash, err := util.HashPassword("pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setTestConfig(t, func(c *util.Config) {
+		c.Admin.PasswordHash = hash
+		c.Admin.SessionSecret = "test"
+	})
+
+	mr := miniredis.RunT(t)
+	old := util.DataStore
+	util.DataStore = util.NewRedisStore(util.NewRedisClient(mr.Addr(), "", 0, nil))
+	t.Cleanup(func() {
+		util.DataStore.Close()
+		util.DataStore = old
+	})
+	util.SetupCollectionCache()
+	util.SetupMapStringCache()
+	util.SetupCollectionStatusCache()
+	util.DiskCache = diskv.New(diskv.Options{BasePath: t.TempDir()})
+
+	if err := util.SetReportToken("n1", testReportToken); err != nil {
+		t.Fatal(err)
+	}
+	return SetupRouter()
+}
+
+func testReport(ts int64, used int) string {
+	return fmt.Sprintf(`{"Timestamp":%d,"Info":{"IPV4":"1.2.3.4","Uptime":"1 day"},"Collection":{`+
+		`"Load":{"user":1,"system":2},`+
+		`"Memory":{"Mem":{"total":"1000","used":"%d","percent":50},"Swap":{"total":"0","used":"0","percent":0}},`+
+		`"Network":{"RX":{"bytes":%d,"packets":10},"TX":{"bytes":2048,"packets":20}},`+
+		`"Disk":{"/":{"total":"100","used":"%d","percent":50}}}}`, ts, used, used*1024, used)
+}
+
+// serve runs a single request against r and returns the recorded response.
+func serve(r http.Handler, method, target string, body io.Reader, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, body)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func postReport(r http.Handler, uuid, body string) *httptest.ResponseRecorder {
+	return serve(r, http.MethodPost, "/api/report/"+uuid, strings.NewReader(body), http.Header{
+		"Authorization": {"Bearer " + testReportToken},
+		"Content-Type":  {"application/json"},
+		"X-Timestamp":   {strconv.FormatInt(time.Now().Unix(), 10)},
+	})
+}
+
+func TestRoutes(t *testing.T) {
+	r := setupTestRouter(t)
+	now := time.Now().Unix()
+	for i, ts := range []int64{now - 120, now - 60} {
+		if w := postReport(r, "n1", testReport(ts, 100*(i+1))); w.Code != http.StatusOK {
+			t.Fatalf("report = %d %s", w.Code, w.Body)
+		}
+	}
+
+	tests := []struct {
+		path     string
+		header   http.Header
+		code     int
+		contains string
+	}{
+		{"/", nil, http.StatusOK, "<html"},
+		// count of the online nodes.
+		{"/", nil, http.StatusOK, " (1)</div>"},
+		{"/list/", nil, http.StatusOK, "1.2.3.4"},
+		{"/list/", http.Header{"X-Requested-With": {"XMLHttpRequest"}}, http.StatusOK, "1.2.3.4"},
+		{"/info/n1", nil, http.StatusOK, "<html"},
+		{"/info/n1", http.Header{"X-Requested-With": {"XMLHttpRequest"}}, http.StatusOK, ""},
+		{"/info/n1?lang=zh", nil, http.StatusOK, "message: '在线'"},
+		{"/metrics", nil, http.StatusOK, `uuid="n1"`},
+		{"/metrics", nil, http.StatusOK, "server_monitor_collection_cache_hits_total "},
+		{"/api/cpu/n1", nil, http.StatusOK, "user"},
+		{"/api/memory/n1", nil, http.StatusOK, "Mem"},
+		{"/api/memory/n1?start=bad", nil, http.StatusBadRequest, "error"},
+		{"/api/disk/n1", nil, http.StatusOK, "/"},
+		{"/api/network/n1", nil, http.StatusOK, "RX"},
+		{"/api/io/n1", nil, http.StatusOK, ""},
+		{"/api/ping/n1", nil, http.StatusOK, ""},
+		{"/api/thermal/n1", nil, http.StatusOK, ""},
+		{"/api/battery/n1", nil, http.StatusOK, ""},
+		{"/api/memory/unknown", nil, http.StatusInternalServerError, "no data found"},
+		{"/api/stream?uuid=bad/uuid", nil, http.StatusBadRequest, "invalid uuid"},
+		{"/static/manifest/icons-192.png", nil, http.StatusOK, ""},
+		{"/favicon.ico", nil, http.StatusOK, ""},
+		{"/manifest.json", nil, http.StatusOK, "icons"},
+		{"/missing", nil, http.StatusNotFound, "Something is wrong"},
+	}
+	for _, tt := range tests {
+		w := serve(r, http.MethodGet, tt.path, nil, tt.header)
+		if w.Code != tt.code {
+			t.Errorf("GET %s = %d, want %d: %s", tt.path, w.Code, tt.code, w.Body)
+			continue
+		}
+		if !strings.Contains(w.Body.String(), tt.contains) {
+			t.Errorf("GET %s does not contain %q: %s", tt.path, tt.contains, w.Body)
+		}
+	}
+}
+
+func TestReportAuth(t *testing.T) {
+	r := setupTestRouter(t)
+	now := time.Now().Unix()
+	body := testReport(now, 100)
+
+	sign := func(ts int64, body string) http.Header {
+		mac := hmac.New(sha256.New, []byte(testReportToken))
+		fmt.Fprintf(mac, "%d.", ts)
+		mac.Write([]byte(body))
+		return http.Header{
+			"X-Timestamp": {strconv.FormatInt(ts, 10)},
+			"X-Signature": {"sha256=" + hex.EncodeToString(mac.Sum(nil))},
+		}
+	}
+
+	bearer := func() http.Header {
+		return http.Header{
+			"Authorization": {"Bearer " + testReportToken},
+			"X-Timestamp":   {strconv.FormatInt(now, 10)},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		uuid   string
+		body   string
+		header http.Header
+		code   int
+	}{
+		{"missing credentials", "n1", body, nil, http.StatusUnauthorized},
+		{"wrong token", "n1", body, http.Header{"Authorization": {"Bearer wrong"}, "X-Timestamp": {strconv.FormatInt(now, 10)}}, http.StatusForbidden},
+		{"unknown node", "n2", body, bearer(), http.StatusForbidden},
+		{"invalid report", "n1", `{"Collection":{}}`, bearer(), http.StatusBadRequest},
+		{"bearer without timestamp", "n1", body, http.Header{"Authorization": {"Bearer " + testReportToken}}, http.StatusUnauthorized},
+		{"bearer", "n1", body, bearer(), http.StatusOK},
+		{"bearer replayed", "n1", body, bearer(), http.StatusUnauthorized},
+		{"stale timestamp", "n1", body, sign(now-3600, body), http.StatusUnauthorized},
+		{"bad signature", "n1", body, sign(now, body+" "), http.StatusForbidden},
+		{"signed", "n1", body, sign(now, body), http.StatusOK},
+		{"replayed", "n1", body, sign(now, body), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		w := serve(r, http.MethodPost, "/api/report/"+tt.uuid, strings.NewReader(tt.body), tt.header)
+		if w.Code != tt.code {
+			t.Errorf("%s: POST /api/report/%s = %d, want %d: %s", tt.name, tt.uuid, w.Code, tt.code, w.Body)
+		}
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	r := setupTestRouter(t)
+	if w := postReport(r, "n1", testReport(time.Now().Unix(), 100)); w.Code != http.StatusOK {
+		t.Fatalf("report = %d %s", w.Code, w.Body)
+	}
+
+	if w := serve(r, http.MethodGet, "/admin/login", nil, nil); w.Code != http.StatusOK {
+		t.Errorf("GET /admin/login = %d", w.Code)
+	}
+	if w := serve(r, http.MethodGet, "/admin/", nil, nil); w.Code != http.StatusFound {
+		t.Errorf("GET /admin/ without session = %d, want %d", w.Code, http.StatusFound)
+	}
+	if w := serve(r, http.MethodPost, "/admin/purge", nil, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("POST /admin/purge without session = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	login := func(password string) *httptest.ResponseRecorder {
+		form := url.Values{"username": {"admin"}, "password": {password}}
+		return serve(r, http.MethodPost, "/admin/login", strings.NewReader(form.Encode()), http.Header{
+			"Content-Type": {"application/x-www-form-urlencoded"},
+		})
+	}
+	if w := login("wrong"); w.Code != http.StatusUnauthorized {
+		t.Errorf("login with a wrong password = %d", w.Code)
+	}
+	w := login("pw")
+	if w.Code != http.StatusOK {
+		t.Fatalf("login = %d %s", w.Code, w.Body)
+	}
+	var cookie string
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "admin_session" {
+			cookie = c.Name + "=" + c.Value
+		}
+	}
+
+	w = serve(r, http.MethodGet, "/admin/", nil, http.Header{"Cookie": {cookie}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /admin/ = %d", w.Code)
+	}
+	_, csrf, _ := strings.Cut(w.Body.String(), `name="csrf-token" content="`)
+	csrf, _, _ = strings.Cut(csrf, `"`)
+	if csrf == "" {
+		t.Fatal("admin page has no csrf token")
+	}
+
+	admin := func(method, target string, form url.Values, token string) *httptest.ResponseRecorder {
+		header := http.Header{
+			"Cookie":           {cookie},
+			"Content-Type":     {"application/x-www-form-urlencoded"},
+			"X-Requested-With": {"XMLHttpRequest"},
+		}
+		if token != "" {
+			header.Set("X-CSRF-Token", token)
+		}
+		return serve(r, method, target, strings.NewReader(form.Encode()), header)
+	}
+
+	tests := []struct {
+		method, path string
+		form         url.Values
+		token        string
+		code         int
+		contains     string
+	}{
+		{http.MethodPatch, "/admin/node/n1", url.Values{"rename": {"web"}}, "", http.StatusForbidden, "csrf"},
+		{http.MethodPatch, "/admin/node/n1", url.Values{"rename": {"web"}}, "wrong", http.StatusForbidden, "csrf"},
+		{http.MethodPatch, "/admin/node/n1", url.Values{"rename": {"web"}}, csrf, http.StatusOK, "ok"},
+		{http.MethodPatch, "/admin/node/n1", url.Values{"display": {"maybe"}}, csrf, http.StatusBadRequest, "display"},
+		{http.MethodPatch, "/admin/node/n1", url.Values{"display": {"0"}}, csrf, http.StatusOK, "ok"},
+		{http.MethodPatch, "/admin/node/n1", nil, csrf, http.StatusBadRequest, "nothing to update"},
+		{http.MethodGet, "/admin/", nil, "", http.StatusOK, "web"},
+		// Names are only ever written escaped, the info dialog reads them from data attributes.
+		{http.MethodPatch, "/admin/node/n1", url.Values{"rename": {"<b>web</b>"}}, csrf, http.StatusOK, "ok"},
+		{http.MethodGet, "/admin/", nil, "", http.StatusOK, `data-name="&lt;b&gt;web&lt;/b&gt;"`},
+		{http.MethodPost, "/admin/node/n1/token", nil, csrf, http.StatusOK, "token"},
+		{http.MethodPost, "/admin/node/bad%20uuid/token", nil, csrf, http.StatusBadRequest, "invalid uuid"},
+		{http.MethodPatch, "/admin/node/bad%20uuid", url.Values{"rename": {"web"}}, csrf, http.StatusBadRequest, "invalid uuid"},
+		{http.MethodDelete, "/admin/node/bad%20uuid", nil, csrf, http.StatusBadRequest, "invalid uuid"},
+		{http.MethodPost, "/admin/clear", nil, csrf, http.StatusOK, "removed"},
+		{http.MethodDelete, "/admin/node/n1", nil, csrf, http.StatusOK, "ok"},
+		{http.MethodPost, "/admin/purge", nil, csrf, http.StatusOK, "removed"},
+		{http.MethodPost, "/admin/logout", nil, csrf, http.StatusOK, "ok"},
+	}
+	for _, tt := range tests {
+		w := admin(tt.method, tt.path, tt.form, tt.token)
+		if w.Code != tt.code {
+			t.Errorf("%s %s = %d, want %d: %s", tt.method, tt.path, w.Code, tt.code, w.Body)
+			continue
+		}
+		if !strings.Contains(w.Body.String(), tt.contains) {
+			t.Errorf("%s %s does not contain %q: %s", tt.method, tt.path, tt.contains, w.Body)
+		}
+	}
+
+	if w := serve(r, http.MethodGet, "/list/", nil, nil); strings.Contains(w.Body.String(), "1.2.3.4") {
+		t.Error("deleted node is still listed")
+	}
+	// The cookie of a logged out session is revoked, not only cleared.
+	if w := admin(http.MethodGet, "/admin/", nil, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /admin/ after logout = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestAdminLoginLockout(t *testing.T) {
+	r := setupTestRouter(t)
+	setTestConfig(t, func(c *util.Config) { c.Admin.MaxLoginAttempts = 3 })
+	login := func(password string) int {
+		form := url.Values{"username": {"admin"}, "password": {password}}
+		return serve(r, http.MethodPost, "/admin/login", strings.NewReader(form.Encode()), http.Header{
+			"Content-Type": {"application/x-www-form-urlencoded"},
+		}).Code
+	}
+
+	// Concurrent attempts all start before any password is checked.
+	var wg sync.WaitGroup
+	codes := make([]int, 10)
+	for i := range codes {
+		wg.Go(func() { codes[i] = login("wrong") })
+	}
+	wg.Wait()
+	counts := map[int]int{}
+	for _, code := range codes {
+		counts[code]++
+	}
+	if counts[http.StatusUnauthorized] != 3 || counts[http.StatusTooManyRequests] != 7 {
+		t.Errorf("concurrent wrong logins = %v, want 3 checked and 7 locked out", counts)
+	}
+	if code := login("pw"); code != http.StatusTooManyRequests {
+		t.Errorf("login while locked out = %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
+
+func TestHiddenNode(t *testing.T) {
+	r := setupTestRouter(t)
+	if w := postReport(r, "n1", testReport(time.Now().Unix(), 100)); w.Code != http.StatusOK {
+		t.Fatalf("report = %d %s", w.Code, w.Body)
+	}
+	if err := util.SetNodeHidden("n1", true); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path     string
+		code     int
+		contains string
+	}{
+		{"/info/n1", http.StatusNotFound, "Something is wrong"},
+		{"/api/cpu/n1", http.StatusNotFound, "node not found"},
+		{"/api/battery/n1", http.StatusNotFound, "node not found"},
+	}
+	for _, tt := range tests {
+		w := serve(r, http.MethodGet, tt.path, nil, nil)
+		if w.Code != tt.code || !strings.Contains(w.Body.String(), tt.contains) {
+			t.Errorf("GET %s = %d %s, want %d with %q", tt.path, w.Code, w.Body, tt.code, tt.contains)
+		}
+	}
+	if w := serve(r, http.MethodGet, "/metrics", nil, nil); strings.Contains(w.Body.String(), `uuid="n1"`) {
+		t.Error("hidden node is exported in /metrics")
+	}
+	// A hidden node still reports.
+	if w := postReport(r, "n1", testReport(time.Now().Unix(), 200)); w.Code != http.StatusOK {
+		t.Errorf("report of a hidden node = %d %s", w.Code, w.Body)
+	}
+}
+
+func TestAdminDisabled(t *testing.T) {
+	setupTestRouter(t)
+	setTestConfig(t, func(c *util.Config) {
+		c.Admin.PasswordHash = ""
+		c.Admin.PasswordFile = ""
+	})
+	r := SetupRouter()
+	if w := serve(r, http.MethodGet, "/admin/login", nil, nil); w.Code != http.StatusNotFound {
+		t.Errorf("GET /admin/login without a password hash = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestStream(t *testing.T) {
+	srv := httptest.NewServer(setupTestRouter(t))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/stream?uuid=n1", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	events := make(chan string)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if name, ok := strings.CutPrefix(scanner.Text(), "event:"); ok {
+				events <- name
+			}
+		}
+	}()
+
+	next := func() string {
+		select {
+		case e := <-events:
+			return e
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for an event")
+			return ""
+		}
+	}
+	if e := next(); e != "ready" {
+		t.Fatalf("first event = %q, want ready", e)
+	}
+
+	if w := postReport(srv.Config.Handler, "n1", testReport(time.Now().Unix(), 100)); w.Code != http.StatusOK {
+		t.Fatalf("report = %d %s", w.Code, w.Body)
+	}
+	for {
+		if e := next(); e == util.EventCollection {
+			break
+		}
+	}
+}
+
+func TestRunServerShutdown(t *testing.T) {
+	r := setupTestRouter(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	setTestConfig(t, func(c *util.Config) {
+		c.Server.ListenAddress = "127.0.0.1"
+		c.Server.ListenPort = port
+		c.Server.ShutdownTimeout = 5
+	})
+
+	ctx, stop := context.WithCancel(context.Background())
+	cronDone := make(chan struct{})
+	close(cronDone)
+	result := make(chan error, 1)
+	go func() { result <- runServer(ctx, r, cronDone) }()
+
+	var resp *http.Response
+	for i := 0; ; i++ {
+		resp, err = http.Get(fmt.Sprintf("http://127.0.0.1:%d/api/stream", port))
+		if err == nil {
+			break
+		}
+		if i == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	defer resp.Body.Close()
+	line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+	if !strings.HasPrefix(line, "event:ready") {
+		t.Fatalf("first line = %q, want the ready event", line)
+	}
+
+	// The open stream must not hold the shutdown until its timeout.
+	start := time.Now()
+	stop()
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("runServer() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("runServer did not return after its context was done")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("shutdown took %s", d)
+	}
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Errorf("stream was not ended cleanly: %v", err)
+	}
+}